@@ -0,0 +1,304 @@
+package myrpc
+
+import (
+	"errors"
+	"fmt"
+	"myrpc/codec"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddr is wrapped by every error ParseAddr returns.
+var ErrInvalidAddr = errors.New("rpc: invalid address")
+
+// Addr is a parsed RPC address, written either as protocol@addr or as a URI:
+//
+//	tcp@10.0.0.1:9999                 tcp://10.0.0.1:9999
+//	unix@/tmp/geerpc.sock             unix:///tmp/geerpc.sock
+//	http@10.0.0.1:7001/rpc            http://10.0.0.1:7001/rpc
+//	ws@10.0.0.1:7001                  ws://10.0.0.1:7001
+//	mem@name                          mem://name
+//
+// Both forms accept a query overriding the Option used to dial the address:
+// codec (gob, msgpack or the full type of a codec in the Option.Codecs used), timeout (connection timeout),
+// handle_timeout, checksum and conns (Option.Conns), e.g. tcp://10.0.0.1:9999?codec=msgpack&timeout=3s.
+// The services option lists the services offered at the address separated by "+",
+// e.g. services=Foo@v1+Foo@v2, see Server.Advertise.
+type Addr struct {
+	Protocol      string
+	Address       string
+	Path          string //http 和 ws 的挂载路径，为空时使用默认路径
+	Codec         codec.Type
+	Timeout       time.Duration
+	HandleTimeout time.Duration
+	Checksum      bool
+	Conns         int
+	Services      []string //为空时认为提供所有服务
+}
+
+// protocolKinds lists the supported protocols and how their address is checked.
+var protocolKinds = map[string]string{
+	"tcp":  "hostport",
+	"tcp4": "hostport",
+	"tcp6": "hostport",
+	"http": "hostport",
+	"ws":   "hostport",
+	"unix": "path",
+	"mem":  "name",
+}
+
+var codecNames = map[string]codec.Type{
+	"gob":     codec.GobType,
+	"msgpack": codec.MsgpackType,
+}
+
+func invalidAddr(s, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidAddr, s, fmt.Sprintf(format, args...))
+}
+
+// ParseAddr parses and validates an address in either form.
+func ParseAddr(s string) (*Addr, error) {
+	a := &Addr{}
+	var query string
+	if i := strings.Index(s, "://"); i >= 0 && (strings.Index(s, "@") < 0 || i < strings.Index(s, "@")) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, invalidAddr(s, "%v", err)
+		}
+		if u.User != nil || u.Fragment != "" {
+			return nil, invalidAddr(s, "unexpected user info or fragment")
+		}
+		a.Protocol, query = u.Scheme, u.RawQuery
+		if protocolKinds[a.Protocol] == "path" {
+			a.Address = u.Host + u.Path
+		} else {
+			a.Address, a.Path = u.Host, u.Path
+		}
+	} else {
+		i := strings.Index(s, "@")
+		if i < 0 {
+			return nil, invalidAddr(s, "expect protocol@addr or protocol://addr")
+		}
+		a.Protocol, a.Address = s[:i], s[i+1:]
+		if j := strings.Index(a.Address, "?"); j >= 0 {
+			a.Address, query = a.Address[:j], a.Address[j+1:]
+		}
+		if protocolKinds[a.Protocol] == "hostport" {
+			if j := strings.Index(a.Address, "/"); j >= 0 {
+				a.Address, a.Path = a.Address[:j], a.Address[j:]
+			}
+		}
+	}
+	if err := a.validate(); err != nil {
+		return nil, invalidAddr(s, "%v", err)
+	}
+	if err := a.parseQuery(query); err != nil {
+		return nil, invalidAddr(s, "%v", err)
+	}
+	return a, nil
+}
+
+func (a *Addr) validate() error {
+	kind, ok := protocolKinds[a.Protocol]
+	if !ok {
+		return fmt.Errorf("unsupported protocol %q", a.Protocol)
+	}
+	if a.Address == "" {
+		return errors.New("missing address")
+	}
+	if a.Path != "" && a.Protocol != "http" && a.Protocol != "ws" {
+		return fmt.Errorf("protocol %s doesn't take a path", a.Protocol)
+	}
+	if kind != "hostport" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func (a *Addr) parseQuery(query string) error {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for key, vs := range values {
+		v := vs[len(vs)-1]
+		switch key {
+		case "codec":
+			t, ok := codecNames[v]
+			if !ok {
+				//其余编解码器要写完整的类型，是否注册由拨号或服务时用的 Registry 检查
+				if !strings.Contains(v, "/") {
+					return fmt.Errorf("unknown codec %q", v)
+				}
+				t = codec.Type(v)
+			}
+			a.Codec = t
+		case "timeout", "handle_timeout":
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid %s %q", key, v)
+			}
+			if key == "timeout" {
+				a.Timeout = d
+			} else {
+				a.HandleTimeout = d
+			}
+		case "services":
+			a.Services = nil
+			for _, name := range strings.Fields(v) {
+				if err := checkServiceName(name); err != nil {
+					return err
+				}
+				a.Services = append(a.Services, name)
+			}
+		case "conns":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid conns %q", v)
+			}
+			a.Conns = n
+		case "checksum":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid checksum %q", v)
+			}
+			a.Checksum = b
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return nil
+}
+
+// String returns the address in the protocol@addr form, options included.
+func (a *Addr) String() string {
+	s := a.Protocol + "@" + a.Address + a.Path
+	values := url.Values{}
+	if a.Codec != "" {
+		name := string(a.Codec)
+		for n, t := range codecNames {
+			if t == a.Codec {
+				name = n
+			}
+		}
+		values.Set("codec", name)
+	}
+	if a.Timeout != 0 {
+		values.Set("timeout", a.Timeout.String())
+	}
+	if a.HandleTimeout != 0 {
+		values.Set("handle_timeout", a.HandleTimeout.String())
+	}
+	if a.Checksum {
+		values.Set("checksum", "true")
+	}
+	if a.Conns != 0 {
+		values.Set("conns", strconv.Itoa(a.Conns))
+	}
+	if len(a.Services) > 0 {
+		values.Set("services", strings.Join(a.Services, " "))
+	}
+	if len(values) == 0 {
+		return s
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	query := make([]string, 0, len(keys))
+	for _, k := range keys {
+		//服务名中的 @ 在 query 中无需转义，保持地址可读
+		v := strings.ReplaceAll(url.QueryEscape(values.Get(k)), "%40", "@")
+		query = append(query, k+"="+v)
+	}
+	return s + "?" + strings.Join(query, "&")
+}
+
+// Offers tells whether the address offers service, "Foo" matches every version of Foo.
+func (a *Addr) Offers(service string) bool {
+	if len(a.Services) == 0 {
+		return true
+	}
+	for _, name := range a.Services {
+		if base, _ := splitVersion(name); name == service || base == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Option returns a copy of opt with the address's options applied,
+// opt itself when the address has none.
+func (a *Addr) Option(opt *Option) *Option {
+	if a.Codec == "" && a.Timeout == 0 && a.HandleTimeout == 0 && !a.Checksum && a.Conns == 0 {
+		return opt
+	}
+	o := *opt
+	if a.Codec != "" {
+		o.CodeType = a.Codec
+	}
+	if a.Timeout != 0 {
+		o.ConnectionTimeOut = a.Timeout
+	}
+	if a.HandleTimeout != 0 {
+		o.HandleTimeOut = a.HandleTimeout
+	}
+	if a.Checksum {
+		o.Checksum = true
+	}
+	if a.Conns != 0 {
+		o.Conns = a.Conns
+	}
+	return &o
+}
+
+// checkCodec makes sure the codec of the address is in codecs, nil meaning codec.DefaultRegistry.
+func (a *Addr) checkCodec(codecs *codec.Registry) error {
+	if codecs == nil {
+		codecs = codec.DefaultRegistry
+	}
+	if _, ok := codecs.Lookup(a.Codec); a.Codec != "" && !ok {
+		return invalidAddr(a.String(), "unknown codec %q", a.Codec)
+	}
+	return nil
+}
+
+// Dial connects to the address, its options override those of opts.
+func (a *Addr) Dial(opts ...*Option) (*Client, error) {
+	opt, err := prepareOption(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkCodec(opt.Codecs); err != nil {
+		return nil, err
+	}
+	opt = a.Option(opt)
+	switch a.Protocol {
+	case "http":
+		path := a.Path
+		if path == "" {
+			path = defaultRPCPath
+		}
+		return DialHTTPPath("tcp", a.Address, path, opt)
+	case "ws":
+		path := a.Path
+		if path == "" {
+			path = defaultWSPath
+		}
+		return DialWebSocket("tcp", a.Address, path, opt)
+	default:
+		return Dial(a.Protocol, a.Address, opt)
+	}
+}
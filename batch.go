@@ -0,0 +1,182 @@
+package myrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"myrpc/codec"
+	"sync"
+	"time"
+)
+
+// BatchOption is the body of a batch request header.
+type BatchOption struct {
+	Concurrent bool //为 true 时服务端并发执行各个请求，否则按顺序逐个执行
+}
+
+// Batch queues several calls that are sent to the server as a single frame,
+// executed there and answered with a single batch response.
+type Batch struct {
+	client     *Client
+	Concurrent bool
+	Items      []*BatchItem
+}
+
+// BatchItem is one call of a Batch, Error is set per item once the batch is done.
+type BatchItem struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	Error         error
+}
+
+func (client *Client) NewBatch() *Batch {
+	return &Batch{client: client}
+}
+
+func (b *Batch) Add(serviceMethod string, args, reply interface{}) *BatchItem {
+	item := &BatchItem{ServiceMethod: serviceMethod, Args: args, Reply: reply}
+	b.Items = append(b.Items, item)
+	return item
+}
+
+// Do sends the batch and waits for its response. The returned error concerns
+// the batch as a whole, e.g. a broken connection, errors of the individual
+// calls are reported in each item.
+func (b *Batch) Do(ctx context.Context) error {
+	if len(b.Items) == 0 {
+		return nil
+	}
+	call := &Call{
+		Args:  b,
+		Reply: b,
+		Done:  make(chan *Call, 1),
+		batch: b,
+	}
+	b.client.send(call)
+	select {
+	case <-ctx.Done():
+		call.conn.removeCall(call.Seq)
+		return errors.New("rpc client: batch failed: " + ctx.Err().Error())
+	case <-call.Done:
+		return call.Error
+	}
+}
+
+// writeBatch sends the batch header followed by every item in one frame.
+func (client *Client) writeBatch(seq uint64, b *Batch) error {
+	hs := make([]*codec.Header, 0, len(b.Items)+1)
+	bodies := make([]interface{}, 0, len(b.Items)+1)
+	hs = append(hs, &codec.Header{Seq: seq, Batch: len(b.Items)})
+	bodies = append(bodies, &BatchOption{Concurrent: b.Concurrent})
+	for i, item := range b.Items {
+		hs = append(hs, &codec.Header{ServeiceMethod: item.ServiceMethod, Seq: uint64(i)})
+		bodies = append(bodies, item.Args)
+	}
+	return client.w.WriteBatch(hs, bodies)
+}
+
+// readBatch reads the items of a batch response, call is nil if it was canceled.
+func (client *Client) readBatch(h *codec.Header, call *Call) error {
+	if err := client.cc.ReadBody(nil); err != nil {
+		return err
+	}
+	var items []*BatchItem
+	if call != nil {
+		items = call.batch.Items
+	}
+	for i := 0; i < h.Batch; i++ {
+		var ih codec.Header
+		if err := client.cc.ReadHeader(&ih); err != nil {
+			return err
+		}
+		var item *BatchItem
+		if ih.Seq < uint64(len(items)) {
+			item = items[ih.Seq]
+		}
+		if item == nil || ih.Error != "" {
+			if err := client.cc.ReadBody(nil); err != nil {
+				return err
+			}
+			if item != nil {
+				item.Error = errors.New(ih.Error)
+			}
+			continue
+		}
+		if err := client.cc.ReadBody(item.Reply); err != nil {
+			item.Error = errors.New("reading body " + err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// readBatch reads the option and the requests following a batch header.
+// Problems with a single item are kept in that item, the others still run.
+func (server *Server) readBatch(cc codec.Codec, h *codec.Header, codecType codec.Type) (*request, error) {
+	req := &request{h: h, codecType: codecType}
+	if err := cc.ReadBody(&req.batchOpt); err != nil {
+		return nil, err
+	}
+	for i := 0; i < h.Batch; i++ {
+		item, err := server.readRequest(cc, codecType)
+		if item == nil || errors.Is(err, codec.ErrChecksum) {
+			return nil, err
+		}
+		if item.batch != nil {
+			return nil, errors.New("rpc server: nested batch request")
+		}
+		item.err = err
+		req.batch = append(req.batch, item)
+	}
+	return req, nil
+}
+
+func (server *Server) handleBatch(req *request, wg *sync.WaitGroup, w *connWriter, timeOut time.Duration) {
+	defer wg.Done()
+	n := len(req.batch)
+	replies := make([]interface{}, n)
+	errs := make([]error, n)
+	run := func(i int) {
+		item := req.batch[i]
+		if item.err != nil {
+			errs[i] = item.err
+			return
+		}
+		replies[i], errs[i] = server.invoke(item, timeOut)
+	}
+	if req.batchOpt.Concurrent {
+		var running sync.WaitGroup
+		running.Add(n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				defer running.Done()
+				run(i)
+			}(i)
+		}
+		running.Wait()
+	} else {
+		for i := 0; i < n; i++ {
+			run(i)
+		}
+	}
+
+	hs := make([]*codec.Header, 0, n+1)
+	bodies := make([]interface{}, 0, n+1)
+	hs = append(hs, &codec.Header{Seq: req.h.Seq, Batch: n})
+	bodies = append(bodies, invalidRequest)
+	for i, item := range req.batch {
+		if errs[i] != nil {
+			item.h.Error = errs[i].Error()
+			replies[i] = invalidRequest
+		}
+		hs = append(hs, item.h)
+		bodies = append(bodies, replies[i])
+	}
+	if err := w.WriteBatch(hs, bodies); err != nil {
+		log.Println("rpc server: write batch response error:", err)
+	}
+	for _, item := range req.batch {
+		item.release()
+	}
+}
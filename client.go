@@ -11,8 +11,8 @@ import (
 	"myrpc/codec"
 	"net"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +23,10 @@ type Call struct {
 	Reply         interface{}
 	Error         error
 	Done          chan *Call
+	batch         *Batch //非空时为批量调用
+	version       string //WithVersion 指定的服务版本，写入请求头
+	header        codec.Header
+	conn          *Client //发送请求的连接
 }
 
 func (c *Call) done() {
@@ -30,44 +34,47 @@ func (c *Call) done() {
 }
 
 type Client struct {
+	seq      uint64 //原子操作，放在最前面保证 32 位平台上 64 位对齐
+	inflight int64  //原子操作，未完成的请求数
 	cc       codec.Codec
-	seq      uint64
-	pending  map[uint64]*Call //存储未处理完的请求
+	pending  *pendingTable //存储未处理完的请求
 	opt      *Option
-	closing  bool
-	shutdown bool
-	sending  sync.Mutex //为了保证请求的有序发送，即防止出现多个请求报文混淆
-	mu       sync.Mutex
-	header   codec.Header
+	closing  int32       //原子操作，调用过 Close
+	shutdown int32       //原子操作，连接已断开
+	w        *connWriter //合并并发请求的写入，保证请求报文不会混淆
+	peers    []*Client   //Option.Conns 打开的其余连接
 }
 
 func (client *Client) registerCall(call *Call) (uint64, error) {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if atomic.LoadInt32(&client.closing) != 0 {
 		return 0, Errshutdown
 	}
-	call.Seq = client.seq
-	client.pending[client.seq] = call
-	client.seq++
+	//连接断开后 pending 已关闭，add 会失败
+	call.Seq = atomic.AddUint64(&client.seq, 1)
+	//先计数再加入 pending，响应或断开时的减一不会早于这里的加一
+	atomic.AddInt64(&client.inflight, 1)
+	if err := client.pending.add(call.Seq, call); err != nil {
+		atomic.AddInt64(&client.inflight, -1)
+		return 0, err
+	}
 	return call.Seq, nil
 }
 func (client *Client) removeCall(seq uint64) *Call {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-	call := client.pending[seq]
-	delete(client.pending, seq)
+	call := client.pending.remove(seq)
+	if call != nil {
+		atomic.AddInt64(&client.inflight, -1)
+	}
 	return call
 }
 func (client *Client) terminateCall(err error) {
-	client.sending.Lock()
-	client.mu.Lock()
-	defer func() {
-		client.mu.Unlock()
-		client.sending.Unlock()
-	}()
-	client.shutdown = true
-	for _, call := range client.pending {
+	client.w.Close()
+	atomic.StoreInt32(&client.shutdown, 1)
+	if errors.Is(err, codec.ErrChecksum) {
+		//数据已损坏，关闭连接
+		client.cc.Close()
+	}
+	for _, call := range client.pending.close() {
+		atomic.AddInt64(&client.inflight, -1)
 		call.Error = err
 		call.done()
 	}
@@ -80,12 +87,50 @@ type clientResult struct {
 
 type newClientFunc func(net.Conn, *Option) (*Client, error)
 
-func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (*Client, error) {
 	opt, err := prepareOption(opts...)
 	if err != nil {
 		return nil, err
 	}
-	con, err := net.DialTimeout(network, address, opt.ConnectionTimeOut)
+	//多条连接同时拨号，共用同一个超时
+	n := opt.Conns
+	if n < 1 {
+		n = 1
+	}
+	results := make([]clientResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].client, results[i].err = dialOne(f, network, address, opt)
+		}(i)
+	}
+	wg.Wait()
+	for _, res := range results {
+		if res.err != nil {
+			err = res.err
+			break
+		}
+	}
+	if err != nil {
+		for _, res := range results {
+			if res.client != nil {
+				res.client.Close()
+			}
+		}
+		return nil, err
+	}
+	client := results[0].client
+	for _, res := range results[1:] {
+		client.peers = append(client.peers, res.client)
+	}
+	return client, nil
+}
+
+// dialOne opens a connection and sets up a client on it within opt.ConnectionTimeOut.
+func dialOne(f newClientFunc, network, address string, opt *Option) (client *Client, err error) {
+	con, err := dialConn(network, address, opt.ConnectionTimeOut)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +157,14 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 
 }
 
+// dialConn is net.DialTimeout, plus the "mem" network of ListenMem.
+func dialConn(network, address string, timeout time.Duration) (net.Conn, error) {
+	if network == "mem" {
+		return DialMemConn(address, timeout)
+	}
+	return net.DialTimeout(network, address, timeout)
+}
+
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
 	return dialTimeout(NewClient, network, address, opts...)
 }
@@ -123,64 +176,119 @@ func prepareOption(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
+	//复制一份再补全，同一个 Option 可能被并发拨号共用
+	opt := *opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodeType == "" {
 		opt.CodeType = DefaultOption.CodeType
 	}
-	return opt, nil
+	return &opt, nil
 }
 
 func NewClient(con net.Conn, opt *Option) (*Client, error) {
-	newfunc := codec.NewCodecFuncMap[opt.CodeType]
-	if newfunc == nil {
+	codecs := opt.Codecs
+	if codecs == nil {
+		codecs = codec.DefaultRegistry
+	}
+	newfunc, ok := codecs.Lookup(opt.CodeType)
+	if !ok {
 		err := fmt.Errorf("invalid codec type %s", opt.CodeType)
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
+	if opt.NetRPC {
+		return newClientCodec(codec.NewNetRPCCodec(con), opt), nil
+	}
 	if err := json.NewEncoder(con).Encode(opt); err != nil {
 		log.Println("rpc client: options error: ", err)
 		con.Close()
 		return nil, err
 	}
+	var conn io.ReadWriteCloser = con
+	if opt.Checksum {
+		conn = codec.NewChecksumConn(con)
+	}
+	return newClientCodec(newfunc(conn), opt), nil
+}
+
+func newClientCodec(cc codec.Codec, opt *Option) *Client {
 	client := &Client{
-		cc:      newfunc(con),
-		seq:     1,
+		cc:      cc,
 		opt:     opt,
-		pending: make(map[uint64]*Call),
+		pending: newPendingTable(),
+		w:       newConnWriter(cc),
 	}
 	go client.recieve()
-	return client, nil
+	return client
 }
 
 var Errshutdown = errors.New("connection is shutdown")
 
 func (c *Client) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.closing {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
 		return Errshutdown
 	}
+	for _, peer := range c.peers {
+		peer.Close()
+	}
+	c.w.Close()
 	c.cc.Close()
-	c.closing = true
 	return nil
 }
 
+// IsAvaliable reports whether one of the connections of the client is still up.
 func (c *Client) IsAvaliable() bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return !c.shutdown && !c.closing
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return false
+	}
+	if c.connAvaliable() {
+		return true
+	}
+	for _, peer := range c.peers {
+		if peer.connAvaliable() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) connAvaliable() bool {
+	return atomic.LoadInt32(&c.shutdown) == 0 && atomic.LoadInt32(&c.closing) == 0
+}
+
+// pick returns the connection with the fewest calls in flight, skipping the broken ones.
+func (c *Client) pick() *Client {
+	best := c
+	for _, peer := range c.peers {
+		if !peer.connAvaliable() {
+			continue
+		}
+		if !best.connAvaliable() || atomic.LoadInt64(&peer.inflight) < atomic.LoadInt64(&best.inflight) {
+			best = peer
+		}
+	}
+	return best
 }
 
 func (client *Client) recieve() {
 	var err error
 	for err == nil {
 		var h codec.Header
-		if err := client.cc.ReadHeader(&h); err != nil {
+		if err = client.cc.ReadHeader(&h); err != nil {
+			break
+		}
+		if h.Seq == 0 && h.Error == codec.ErrChecksum.Error() {
+			//服务端收到损坏的帧，认不出请求，之后会断开连接
+			err = codec.ErrChecksum
 			break
 		}
 		call := client.removeCall(h.Seq)
 		switch {
+		case h.Batch > 0:
+			if err = client.readBatch(&h, call); call != nil {
+				call.Error = err
+				call.done()
+			}
 		case call == nil:
 			//可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了。
 			err = client.cc.ReadBody(nil)
@@ -188,7 +296,10 @@ func (client *Client) recieve() {
 			{
 				//call 存在，但服务端处理出错
 				err = client.cc.ReadBody(nil)
-				call.Error = fmt.Errorf(h.Error)
+				call.Error = errors.New(h.Error)
+				if h.Error == codec.ErrChecksum.Error() {
+					call.Error = codec.ErrChecksum
+				}
 				call.done()
 			}
 		default:
@@ -196,7 +307,7 @@ func (client *Client) recieve() {
 				err = client.cc.ReadBody(call.Reply)
 				//call 存在，服务端处理正常
 				if err != nil {
-					call.Error = errors.New("reading body " + err.Error())
+					call.Error = fmt.Errorf("reading body: %w", err)
 				}
 				call.done()
 			}
@@ -205,20 +316,44 @@ func (client *Client) recieve() {
 	client.terminateCall(err)
 }
 
+func isRawMessage(v interface{}) bool {
+	switch v.(type) {
+	case codec.RawMessage, *codec.RawMessage:
+		return true
+	}
+	return false
+}
+
+// send sends call over the least busy connection.
 func (client *Client) send(call *Call) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
+	call.conn = client.pick()
+	call.conn.sendConn(call)
+}
+
+func (client *Client) sendConn(call *Call) {
+	if call.batch != nil && client.opt.NetRPC {
+		call.Error = errors.New("rpc client: batch calls are not supported by net/rpc servers")
+		call.done()
+		return
+	}
+	if _, ok := client.cc.(codec.RawCodec); !ok && (isRawMessage(call.Args) || isRawMessage(call.Reply)) {
+		call.Error = fmt.Errorf("rpc client: codec %s does not support raw messages", client.opt.CodeType)
+		call.done()
+		return
+	}
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
 		call.done()
 		return
 	}
-	client.header.ServeiceMethod = call.serviceMethod
-	client.header.Seq = seq
-	client.header.Error = ""
-
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	if call.batch != nil {
+		err = client.writeBatch(seq, call.batch)
+	} else {
+		call.header = codec.Header{ServeiceMethod: call.serviceMethod, Seq: seq, Version: call.version}
+		err = client.w.Write(&call.header, call.Args)
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		if call != nil {
 			call.Error = err
@@ -228,6 +363,10 @@ func (client *Client) send(call *Call) {
 }
 
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return client.goVersion(serviceMethod, "", args, reply, done)
+}
+
+func (client *Client) goVersion(serviceMethod, version string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call)
 	} else if cap(done) == 0 {
@@ -239,16 +378,17 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		version:       version,
 	}
 	client.send(call)
 	return call
 }
 
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := client.goVersion(serviceMethod, VersionFrom(ctx), args, reply, make(chan *Call, 1))
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
+		call.conn.removeCall(call.Seq)
 		return errors.New("rpc client: call failed: " + ctx.Err().Error())
 	case <-call.Done:
 		return call.Error
@@ -256,36 +396,42 @@ func (client *Client) Call(ctx context.Context, serviceMethod string, args, repl
 }
 
 func NewHTTPClient(con net.Conn, opt *Option) (*Client, error) {
-	io.WriteString(con, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
-	response, err := http.ReadResponse(bufio.NewReader(con), &http.Request{Method: "CONNECT"})
-	if err == nil && response.Status == connected {
-		return NewClient(con, opt)
-	}
-	if err == nil {
-		err = errors.New("unexpected HTTP response: " + response.Status)
+	return newHTTPClient(defaultRPCPath)(con, opt)
+}
+
+// newHTTPClient CONNECTs to the RPC handler mounted at path.
+func newHTTPClient(path string) newClientFunc {
+	return func(con net.Conn, opt *Option) (*Client, error) {
+		io.WriteString(con, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", path))
+		response, err := http.ReadResponse(bufio.NewReader(con), &http.Request{Method: "CONNECT"})
+		if err == nil && response.Status == connected {
+			return NewClient(con, opt)
+		}
+		if err == nil {
+			err = errors.New("unexpected HTTP response: " + response.Status)
+		}
+		return nil, err
 	}
-	return nil, err
 }
 
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	return DialHTTPPath(network, address, defaultRPCPath, opts...)
+}
+
+// DialHTTPPath connects to a server whose RPC handler is mounted at path.
+func DialHTTPPath(network, address, path string, opts ...*Option) (*Client, error) {
+	return dialTimeout(newHTTPClient(path), network, address, opts...)
 }
 
 // XDial calls different functions to connect to a RPC server
 // according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
-// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock, ws@10.0.0.1:7001/_geerpc_/ws, mem@name
+// The URI form and the options described in Addr are accepted as well, e.g. tcp://10.0.0.1:9999?codec=msgpack
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
-	parts := strings.Split(rpcAddr, "@")
-	if len(parts) != 2 {
-
-	}
-	protocol, addr := parts[0], parts[1]
-	switch protocol {
-	case "http":
-		return DialHTTP("tcp", addr, opts...)
-	default:
-		return Dial(protocol, addr, opts...)
+	addr, err := ParseAddr(rpcAddr)
+	if err != nil {
+		return nil, err
 	}
-
+	return addr.Dial(opts...)
 }
@@ -1,11 +1,22 @@
 package myrpc
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"myrpc/codec"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -54,7 +65,8 @@ func TestXxx(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
@@ -77,7 +89,9 @@ func TestXDial(t *testing.T) {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				close(ch)
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
@@ -87,3 +101,533 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestChecksum(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{Checksum: true})
+	_assert(err == nil, "dial with checksum failed: %v", err)
+	defer client.Close()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "checksummed call failed: %v", err)
+
+	//请求和响应方向各翻转一个字节，调用都应得到 ErrChecksum
+	for _, toServer := range []bool{true, false} {
+		c1, c2 := net.Pipe()
+		clientConn, serverConn := net.Conn(c1), net.Conn(c2)
+		if toServer {
+			clientConn = &corruptConn{Conn: c1, n: 2} //第一次写是 Option
+		} else {
+			serverConn = &corruptConn{Conn: c2, n: 1}
+		}
+		go server.ServerCon(serverConn)
+		client, err := NewClient(clientConn, &Option{MagicNumber: MagicNumber, CodeType: codec.GobType, Checksum: true})
+		_assert(err == nil, "new client failed: %v", err)
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(errors.Is(err, codec.ErrChecksum), "corrupted frame (to server: %v) should fail with ErrChecksum, got %v", toServer, err)
+		client.Close()
+	}
+}
+
+// corruptConn flips the last byte of its n-th write.
+type corruptConn struct {
+	net.Conn
+	n int
+}
+
+func (c *corruptConn) Write(p []byte) (int, error) {
+	if c.n--; c.n == 0 {
+		p = append([]byte(nil), p...)
+		p[len(p)-1] ^= 0xff
+	}
+	return c.Conn.Write(p)
+}
+
+func TestRawProxy(t *testing.T) {
+	var foo Foo
+	backend := NewServer()
+	_ = backend.Register(&foo)
+	bl, _ := net.Listen("tcp", ":0")
+	go backend.Accept(bl)
+	upstream, err := Dial("tcp", bl.Addr().String(), &Option{CodeType: codec.MsgpackType})
+	_assert(err == nil, "dial backend failed: %v", err)
+	defer upstream.Close()
+
+	proxy := NewServer()
+	_ = proxy.RegisterRaw("Foo", RawHandlerFunc(func(req *RawRequest) (codec.RawMessage, error) {
+		var reply codec.RawMessage
+		err := upstream.Call(context.Background(), req.ServiceMethod, req.Body, &reply)
+		return reply, err
+	}))
+	pl, _ := net.Listen("tcp", ":0")
+	go proxy.Accept(pl)
+
+	client, err := Dial("tcp", pl.Addr().String(), &Option{CodeType: codec.MsgpackType})
+	_assert(err == nil, "dial proxy failed: %v", err)
+	defer client.Close()
+	var reply Args
+	err = client.Call(context.Background(), "Foo.Swap", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == Args{Num1: 2, Num2: 1}, "proxied call failed: %v %v", err, reply)
+	err = client.Call(context.Background(), "Foo.Unknown", Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect backend error, got %v", err)
+
+	gobClient, _ := Dial("tcp", pl.Addr().String())
+	defer gobClient.Close()
+	err = gobClient.Call(context.Background(), "Foo.Swap", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "does not support raw"), "expect raw codec error, got %v", err)
+}
+
+func TestBatch(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("tcp", l.Addr().String(), &Option{CodeType: typ})
+		_assert(err == nil, "dial failed: %v", err)
+		for _, concurrent := range []bool{false, true} {
+			b := client.NewBatch()
+			b.Concurrent = concurrent
+			var sum int
+			var swapped Args
+			b.Add("Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+			missing := b.Add("Foo.Missing", Args{}, &sum)
+			b.Add("Foo.Swap", Args{Num1: 3, Num2: 4}, &swapped)
+			err = b.Do(context.Background())
+			_assert(err == nil, "batch failed: %v", err)
+			_assert(sum == 3 && swapped == Args{Num1: 4, Num2: 3}, "unexpected replies %d %v", sum, swapped)
+			_assert(missing.Error != nil && strings.Contains(missing.Error.Error(), "can't find method"), "expect item error, got %v", missing.Error)
+		}
+		//批量调用之后普通调用依旧正常
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 5, Num2: 6}, &reply)
+		_assert(err == nil && reply == 11, "call after batch failed: %v", err)
+		client.Close()
+	}
+}
+
+func TestBadArgs(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := ListenMem("bad-args", 0)
+	go server.Accept(l)
+	defer l.Close()
+
+	for _, typ := range []codec.Type{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("mem", "bad-args", &Option{CodeType: typ})
+		_assert(err == nil, "dial failed: %v", err)
+		var reply int
+		bad := struct {
+			Num1 string
+			Num2 int
+		}{"x", 2}
+		err = client.Call(context.Background(), "Foo.Sum", bad, &reply)
+		_assert(err != nil && reply == 0, "%s: call with a mistyped field should fail, got %d %v", typ, reply, err)
+		//同一连接上的后续调用不受影响
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 5, Num2: 6}, &reply)
+		_assert(err == nil && reply == 11, "%s: call after a bad one failed: %v", typ, err)
+		client.Close()
+	}
+}
+
+func TestNetRPCCompat(t *testing.T) {
+	t.Run("stock server", func(t *testing.T) {
+		var foo Foo
+		s := rpc.NewServer()
+		_ = s.Register(&foo)
+		l, _ := net.Listen("tcp", ":0")
+		go s.Accept(l)
+		client, err := Dial("tcp", l.Addr().String(), &Option{NetRPC: true})
+		_assert(err == nil, "dial failed: %v", err)
+		defer client.Close()
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "call failed: %v", err)
+		err = client.Call(context.Background(), "Foo.Nope", Args{}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect error, got %v", err)
+	})
+	t.Run("stock client", func(t *testing.T) {
+		server := newFooServer()
+		server.AcceptNetRPC = true
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+		stock, err := rpc.Dial("tcp", l.Addr().String())
+		_assert(err == nil, "dial failed: %v", err)
+		defer stock.Close()
+		var reply Args
+		err = stock.Call("Foo.Swap", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == Args{Num1: 2, Num2: 1}, "stock call failed: %v", err)
+		err = stock.Call("Foo.Nope", Args{}, &reply)
+		_assert(err != nil, "expect error")
+
+		client, err := Dial("tcp", l.Addr().String())
+		_assert(err == nil, "dial failed: %v", err)
+		defer client.Close()
+		var sum int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "call failed: %v", err)
+	})
+}
+
+func TestWebSocketBadFrames(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"rsv bit":             {wsFinalBit | 0x40 | wsOpBinary, wsMaskBit | 1, 0, 0, 0, 0, 'x'},
+		"fragmented ping":     {wsOpPing, wsMaskBit, 0, 0, 0, 0},
+		"fragmented close":    {wsOpClose, wsMaskBit, 0, 0, 0, 0},
+		"unmasked from peer":  {wsFinalBit | wsOpBinary, 1, 'x'},
+		"reserved opcode 0x3": {wsFinalBit | 0x3, wsMaskBit, 0, 0, 0, 0},
+	} {
+		server, peer := net.Pipe()
+		ws := newWSConn(server, bufio.NewReader(server), false)
+		go func() { _, _ = peer.Write(frame) }()
+		readErr := make(chan error, 1)
+		go func() {
+			_, err := ws.Read(make([]byte, 8))
+			readErr <- err
+		}()
+		//服务端以 1002 关闭连接
+		reply := make([]byte, 4)
+		_, err := io.ReadFull(peer, reply)
+		_assert(err == nil && bytes.Equal(reply, []byte{wsFinalBit | wsOpClose, 2, 0x03, 0xea}), "%s: expect close 1002, got % x %v", name, reply, err)
+		_assert(<-readErr == errWSProtocol, "%s: expect protocol error", name)
+		server.Close()
+		peer.Close()
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	ts := httptest.NewServer(newFooServer().WebSocketHandler())
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	client, err := XDial("ws@"+addr+"/any/path", &Option{CodeType: codec.MsgpackType})
+	_assert(err == nil, "dial websocket failed: %v", err)
+	defer client.Close()
+	var reply Args
+	for i := 0; i < 3; i++ {
+		err = client.Call(context.Background(), "Foo.Swap", Args{Num1: i, Num2: 2}, &reply)
+		_assert(err == nil && reply == Args{Num1: 2, Num2: i}, "call over websocket failed: %v", err)
+	}
+	resp, err := http.Get(ts.URL)
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "plain GET should be rejected")
+	resp.Body.Close()
+
+	upgrade := func(url, origin string) int {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "upgrade failed: %v", err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	_assert(upgrade(ts.URL, "http://evil.example") == http.StatusForbidden, "cross-origin upgrade should be rejected")
+	_assert(upgrade(ts.URL, ts.URL) == http.StatusSwitchingProtocols, "same-origin upgrade should be accepted")
+	server := newFooServer()
+	server.CheckOrigin = func(req *http.Request) bool { return req.Header.Get("Origin") == "http://trusted.example" }
+	ts2 := httptest.NewServer(server.WebSocketHandler())
+	defer ts2.Close()
+	_assert(upgrade(ts2.URL, "http://trusted.example") == http.StatusSwitchingProtocols, "CheckOrigin should allow the trusted origin")
+}
+
+type Baz int
+
+func (b Baz) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2 + 100
+	return nil
+}
+
+func TestHTTPPaths(t *testing.T) {
+	var baz Baz
+	s1, s2 := newFooServer(), NewServer()
+	_ = s2.Register(&baz)
+	mux := http.NewServeMux()
+	s1.HandleHTTP(mux, HTTPPaths{RPC: "/one", Debug: "/debug/one"})
+	s2.HandleHTTP(mux, HTTPPaths{RPC: "/two"})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	var reply int
+	c1, err := XDial("http@" + addr + "/one")
+	_assert(err == nil, "dial /one failed: %v", err)
+	defer c1.Close()
+	err = c1.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call on /one failed: %v", err)
+	c2, err := DialHTTPPath("tcp", addr, "/two")
+	_assert(err == nil, "dial /two failed: %v", err)
+	defer c2.Close()
+	err = c2.Call(context.Background(), "Baz.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 103, "call on /two failed: %v", err)
+	_, err = XDial("http@" + addr)
+	_assert(err != nil, "default path is not mounted, dial should fail")
+
+	resp, err := http.Get(ts.URL + "/debug/one")
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "debug page not mounted")
+	resp.Body.Close()
+
+	//默认路径只挂载 RPC 和调试页面，其余处理器需要显式开启
+	plain := http.NewServeMux()
+	newFooServer().HandleHTTP(plain, DefaultHTTPPaths)
+	for _, path := range []string{defaultJSONPath, defaultGatePath + "Foo/Sum", defaultWSPath, defaultSchemaPath} {
+		_, pattern := plain.Handler(httptest.NewRequest(http.MethodPost, path, nil))
+		_assert(pattern == "", "%s should not be mounted by default", path)
+	}
+}
+
+func TestMemTransport(t *testing.T) {
+	l, err := ListenMem("foo", 16)
+	_assert(err == nil, "listen failed: %v", err)
+	_, err = ListenMem("foo", 0)
+	_assert(err != nil, "duplicate name should fail")
+	go newFooServer().Accept(l)
+
+	client, err := XDial("mem@foo", &Option{Checksum: true})
+	_assert(err == nil, "dial failed: %v", err)
+	var reply Args
+	for i := 0; i < 10; i++ {
+		err = client.Call(context.Background(), "Foo.Swap", Args{Num1: i, Num2: -i}, &reply)
+		_assert(err == nil && reply == Args{Num1: -i, Num2: i}, "call over mem failed: %v", err)
+	}
+	client.Close()
+
+	_ = l.Close()
+	_, err = XDial("mem@foo")
+	_assert(err != nil, "dial after close should fail")
+
+	//拨号查到监听器之后它才关闭，连接不能排进已清理的积压
+	stale, _ := ListenMem("stale", 0)
+	stale.Close()
+	_, err = stale.dial(time.Second)
+	_assert(err != nil && strings.Contains(err.Error(), "connection refused"), "dial after close should be refused, got %v", err)
+
+	c1, _ := ListenMem("deadline", 0)
+	defer c1.Close()
+	con, _ := DialMemConn("deadline", 0)
+	_ = con.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = con.Read(make([]byte, 1))
+	_assert(errors.Is(err, os.ErrDeadlineExceeded), "expect deadline error, got %v", err)
+}
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestServeAndShutdown(t *testing.T) {
+	var slow Slow
+	server := newFooServer()
+	_ = server.Register(&slow)
+	addrs, err := server.Serve("tcp@127.0.0.1:0", "mem@serve-test", "http@127.0.0.1:0/rpc", "ws@127.0.0.1:0")
+	_assert(err == nil && len(addrs) == 4, "serve failed: %v", err)
+	_assert(!strings.HasSuffix(addrs[0], ":0") && strings.HasSuffix(addrs[2], "/rpc"), "unexpected addresses %v", addrs)
+	_, err = server.Serve("tcp@127.0.0.1:0", "bogus")
+	_assert(err != nil, "invalid address should fail")
+
+	var clients []*Client
+	for _, addr := range addrs {
+		client, err := XDial(addr)
+		_assert(err == nil, "dial %s failed: %v", addr, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "call on %s failed: %v", addr, err)
+		clients = append(clients, client)
+	}
+
+	call := clients[0].Go("Slow.Sleep", 200, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	err = server.Shutdown(context.Background())
+	_assert(err == nil, "shutdown failed: %v", err)
+	<-call.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 200, "in-flight call should finish, got %v", call.Error)
+	_, err = XDial(addrs[0])
+	_assert(err != nil, "dial after shutdown should fail")
+}
+
+func TestParseAddr(t *testing.T) {
+	_ = codec.Register("application/x-custom", codec.NewGobCodec) //重复运行时已注册
+	valid := map[string]string{
+		"tcp@127.0.0.1:9999":                        "tcp@127.0.0.1:9999",
+		"tcp://127.0.0.1:9999?codec=gob&timeout=3s": "tcp@127.0.0.1:9999?codec=gob&timeout=3s",
+		"unix:///tmp/geerpc.sock":                   "unix@/tmp/geerpc.sock",
+		"http://[::1]:7001/rpc?checksum=1":          "http@[::1]:7001/rpc?checksum=true",
+		"ws@:7001?handle_timeout=1m":                "ws@:7001?handle_timeout=1m0s",
+		"mem@name?codec=application/x-custom":       "mem@name?codec=application%2Fx-custom",
+		"tcp@127.0.0.1:9999?conns=4":                "tcp@127.0.0.1:9999?conns=4",
+	}
+	for s, want := range valid {
+		a, err := ParseAddr(s)
+		_assert(err == nil, "parse %s failed: %v", s, err)
+		_assert(a.String() == want, "parse %s: expect %s, got %s", s, want, a.String())
+		again, err := ParseAddr(a.String())
+		_assert(err == nil && reflect.DeepEqual(again, a), "%s doesn't round trip", a)
+	}
+	a, _ := ParseAddr("tcp://127.0.0.1:9999?codec=msgpack&timeout=3s")
+	opt := a.Option(DefaultOption)
+	_assert(opt.CodeType == codec.MsgpackType && opt.ConnectionTimeOut == 3*time.Second, "options not applied")
+	_assert(DefaultOption.CodeType == codec.GobType, "DefaultOption must not change")
+
+	invalid := []string{"", "127.0.0.1:9999", "tcp@", "@127.0.0.1:9999", "udp@127.0.0.1:53", "tcp@localhost",
+		"tcp@127.0.0.1:99999", "tcp@127.0.0.1:9999/path", "tcp://127.0.0.1:9999?codec=xml",
+		"tcp@127.0.0.1:9999?timeout=soon", "tcp@127.0.0.1:9999?retries=3", "http://user@host:80", "tcp@127.0.0.1:9999?conns=0",
+		"tcp@127.0.0.1:9999?codec=json"}
+	for _, s := range invalid {
+		_, err := ParseAddr(s)
+		_assert(errors.Is(err, ErrInvalidAddr), "parse %q should fail, got %v", s, err)
+		_, err = XDial(s)
+		_assert(errors.Is(err, ErrInvalidAddr), "XDial %q should fail, got %v", s, err)
+	}
+
+	//完整类型的编解码器在拨号和服务时按所用的 Registry 检查
+	private := "mem@private-codec?codec=application/x-private"
+	_, err := ParseAddr(private)
+	_assert(err == nil, "parse %s failed: %v", private, err)
+	_, err = XDial(private)
+	_assert(errors.Is(err, ErrInvalidAddr) && strings.Contains(err.Error(), "unknown codec"), "XDial with an unregistered codec should fail, got %v", err)
+	_, err = NewServer().Serve(private)
+	_assert(errors.Is(err, ErrInvalidAddr), "Serve with an unregistered codec should fail, got %v", err)
+	reg := codec.DefaultRegistry.Clone()
+	_ = reg.Register("application/x-private", codec.NewGobCodec)
+	privateServer := newFooServer()
+	privateServer.Codecs = reg
+	_, err = privateServer.Serve(private)
+	_assert(err == nil, "serve %s failed: %v", private, err)
+	defer privateServer.Shutdown(context.Background())
+	privateClient, err := XDial(private, &Option{Codecs: reg})
+	_assert(err == nil, "XDial with the codec in Option.Codecs failed: %v", err)
+	privateClient.Close()
+
+	server := newFooServer()
+	addrs, err := server.Serve("tcp://127.0.0.1:0?codec=msgpack")
+	_assert(err == nil && strings.HasSuffix(addrs[0], "?codec=msgpack"), "serve failed: %v %v", addrs, err)
+	defer server.Shutdown(context.Background())
+	client, err := XDial(addrs[0])
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call failed: %v", err)
+}
+
+func TestMultipleConns(t *testing.T) {
+	var slow Slow
+	server := newFooServer()
+	_ = server.Register(&slow)
+	l, _ := ListenMem("conns", 0)
+	defer l.Close()
+	go server.Accept(l)
+
+	client, err := XDial("mem@conns?conns=3")
+	_assert(err == nil && len(client.peers) == 2, "dial failed: %v", err)
+	//调用交给未完成请求最少的连接
+	atomic.StoreInt64(&client.inflight, 2)
+	atomic.StoreInt64(&client.peers[0].inflight, 1)
+	atomic.StoreInt64(&client.peers[1].inflight, 3)
+	_assert(client.pick() == client.peers[0], "pick should choose the least loaded connection")
+	atomic.StoreInt64(&client.peers[0].inflight, 5)
+	_assert(client.pick() == client, "pick should choose the least loaded connection")
+	for _, c := range append(client.peers, client) {
+		atomic.StoreInt64(&c.inflight, 0)
+	}
+
+	//Go 在返回前就把请求计入连接的未完成数，依次发出的请求落在不同连接上
+	done := make(chan *Call, 6)
+	replies := make([]int, 6)
+	for i := range replies {
+		client.Go("Slow.Sleep", 50, &replies[i], done)
+	}
+	for i := 0; i < 6; i++ {
+		call := <-done
+		_assert(call.Error == nil && *call.Reply.(*int) == 50, "call failed: %v", call.Error)
+	}
+	for _, c := range append(client.peers, client) {
+		n := atomic.LoadUint64(&c.seq)
+		_assert(n >= 1, "every connection should take calls, got %d", n)
+	}
+
+	//一条连接断开后，调用走其余连接
+	client.peers[0].Close()
+	var reply int
+	for i := 0; i < 3; i++ {
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "call after a connection closed failed: %v", err)
+	}
+	_assert(client.IsAvaliable(), "client should stay available")
+	client.Close()
+	_assert(!client.IsAvaliable() && !client.peers[1].IsAvaliable(), "close should close every connection")
+}
+
+func TestInflightAfterBreak(t *testing.T) {
+	var slow Slow
+	server := NewServer()
+	_ = server.Register(&slow)
+	l, _ := ListenMem("inflight-break", 0)
+	defer l.Close()
+	go server.Accept(l)
+
+	for round := 0; round < 20; round++ {
+		client, err := Dial("mem", "inflight-break")
+		_assert(err == nil, "dial failed: %v", err)
+		done := make(chan *Call, 100)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var reply int
+				client.Go("Slow.Sleep", 1, &reply, done)
+			}()
+			if i == 50 {
+				//连接在调用进行中断开
+				go client.cc.Close()
+			}
+		}
+		wg.Wait()
+		for i := 0; i < 100; i++ {
+			<-done
+		}
+		n := atomic.LoadInt64(&client.inflight)
+		_assert(n == 0, "round %d: inflight should be 0 once every call is done, got %d", round, n)
+		client.Close()
+	}
+}
+
+func TestPendingTable(t *testing.T) {
+	table := newPendingTable()
+	calls := make([]*Call, 100)
+	for i := range calls {
+		calls[i] = &Call{Seq: uint64(i)}
+		_assert(table.add(uint64(i), calls[i]) == nil, "add %d", i)
+	}
+	_assert(table.remove(7) == calls[7] && table.remove(7) == nil, "remove should return the call once")
+	_assert(len(table.close()) == 99, "close should return the pending calls")
+	_assert(errors.Is(table.add(200, &Call{}), Errshutdown), "add after close should fail")
+}
+
+// BenchmarkPendingTable adds and removes calls from 2000 goroutines per CPU.
+func BenchmarkPendingTable(b *testing.B) {
+	var seq uint64
+	table := newPendingTable()
+	b.SetParallelism(2000)
+	b.RunParallel(func(pb *testing.PB) {
+		call := &Call{}
+		for pb.Next() {
+			s := atomic.AddUint64(&seq, 1)
+			_ = table.add(s, call)
+			table.remove(s)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// ErrChecksum is returned when a frame read from a checksummed connection
+// does not match its CRC32C, the stream can't be trusted after that.
+var ErrChecksum = errors.New("rpc codec: frame checksum mismatch")
+
+const (
+	frameHeaderSize = 8
+	maxFrameSize    = 64 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumConn splits every Write into a frame of
+// [payload length uint32][crc32c uint32][payload] and verifies each frame on Read.
+// The codecs flush once per write, so a frame usually holds one message or a
+// batch of messages written together.
+type checksumConn struct {
+	conn    io.ReadWriteCloser
+	wmu     sync.Mutex
+	pending []byte //已校验但还没被读走的数据
+	err     error  //读出错后不可恢复
+}
+
+func NewChecksumConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &checksumConn{conn: conn}
+}
+
+func (c *checksumConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame := make([]byte, frameHeaderSize+len(p))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(p)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(p, castagnoli))
+	copy(frame[frameHeaderSize:], p)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *checksumConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.err = c.readFrame(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *checksumConn) readFrame() error {
+	var h [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.conn, h[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(h[0:4])
+	if size == 0 || size > maxFrameSize {
+		//长度字段本身被破坏
+		return ErrChecksum
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(h[4:8]) {
+		return ErrChecksum
+	}
+	c.pending = payload
+	return nil
+}
+
+func (c *checksumConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (*bufferConn) Close() error { return nil }
+
+func TestChecksumConn(t *testing.T) {
+	var raw bufferConn
+	cc := NewGobCodec(NewChecksumConn(&raw))
+	if err := cc.Write(&Header{ServeiceMethod: "Foo.Sum", Seq: 1}, 42); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte(nil), raw.Bytes()...)
+
+	var h Header
+	var body int
+	cc = NewGobCodec(NewChecksumConn(&bufferConn{*bytes.NewBuffer(data)}))
+	if err := cc.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("read header: %v %+v", err, h)
+	}
+	if err := cc.ReadBody(&body); err != nil || body != 42 {
+		t.Fatalf("read body: %v %d", err, body)
+	}
+	if err := cc.ReadHeader(&h); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+
+	data[len(data)-1] ^= 0xff
+	cc = NewGobCodec(NewChecksumConn(&bufferConn{*bytes.NewBuffer(data)}))
+	if err := cc.ReadHeader(&h); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expect checksum error, got %v", err)
+	}
+}
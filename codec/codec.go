@@ -1,11 +1,19 @@
 package codec
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
 
 type Header struct {
 	ServeiceMethod string
 	Seq            uint64
 	Error          string
+	Batch          int    //非零时表示这是批量请求/响应的头，后面紧跟 Batch 条消息
+	Version        string //请求的服务版本，ServeiceMethod 没有写明 @version 时生效
 }
 
 type Codec interface {
@@ -15,18 +23,152 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// RawMessage is an encoded body that is passed through without being decoded
+// or re-encoded, e.g. by a proxy. Only codecs implementing RawCodec support it:
+// their Write sends a RawMessage body as is and ReadBody into *RawMessage
+// captures the next body.
+type RawMessage []byte
+
+// BatchWriter is implemented by codecs that can write several messages
+// with a single flush, so they reach the peer as one frame.
+type BatchWriter interface {
+	WriteBatch(hs []*Header, bodies []interface{}) error
+}
+
+// WriteBatch writes the messages in order, with one flush when cc is a BatchWriter.
+func WriteBatch(cc Codec, hs []*Header, bodies []interface{}) error {
+	if bw, ok := cc.(BatchWriter); ok {
+		return bw.WriteBatch(hs, bodies)
+	}
+	for i := range hs {
+		if err := cc.Write(hs[i], bodies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type RawCodec interface {
+	Codec
+	ReadRawBody() (RawMessage, error)
+	WriteRaw(*Header, RawMessage) error
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
 
 const (
-	GobType  Type = "application/gob"
-	JsonType Type = "application/json"
+	GobType     Type = "application/gob"
+	JsonType    Type = "application/json"
+	MsgpackType Type = "application/msgpack"
 )
 
-var NewCodecFuncMap map[Type]NewCodecFunc
+// Registry is a set of codecs keyed by Type, safe for concurrent use.
+// A Server or Client can be given its own Registry to limit or extend
+// the codecs it accepts, otherwise DefaultRegistry is used.
+type Registry struct {
+	mu    sync.RWMutex
+	funcs map[Type]NewCodecFunc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{funcs: make(map[Type]NewCodecFunc)}
+}
+
+// DefaultRegistry holds the built-in codecs and everything added with Register.
+var DefaultRegistry = NewRegistry()
+
+// NewCodecFuncMap mirrors the codecs of DefaultRegistry, codecs added to the
+// map directly are still found by DefaultRegistry.Lookup and listed by Types.
+//
+// Deprecated: use Register and Lookup, the map is not safe for concurrent use.
+var NewCodecFuncMap = make(map[Type]NewCodecFunc)
+
+// Register adds a codec, registering the same Type twice is an error.
+func (r *Registry) Register(typ Type, f NewCodecFunc) error {
+	if typ == "" {
+		return errors.New("rpc codec: register empty codec type")
+	}
+	if f == nil {
+		return fmt.Errorf("rpc codec: register nil codec func for %s", typ)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.funcs[typ]; dup {
+		return fmt.Errorf("rpc codec: codec already registered: %s", typ)
+	}
+	r.funcs[typ] = f
+	if r == DefaultRegistry {
+		NewCodecFuncMap[typ] = f
+	}
+	return nil
+}
+
+func (r *Registry) Lookup(typ Type) (NewCodecFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.funcs[typ]
+	if !ok && r == DefaultRegistry {
+		//兼容直接写入 NewCodecFuncMap 的旧代码
+		f, ok = NewCodecFuncMap[typ]
+	}
+	return f, ok
+}
+
+// Types returns the registered codec types in sorted order.
+func (r *Registry) Types() []Type {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	funcs := r.all()
+	types := make([]Type, 0, len(funcs))
+	for typ := range funcs {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Clone returns a copy that can be extended without touching r.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := NewRegistry()
+	for typ, f := range r.all() {
+		c.funcs[typ] = f
+	}
+	return c
+}
+
+// all returns the codecs of r, for DefaultRegistry those only added to
+// NewCodecFuncMap as well. r.mu must be held.
+func (r *Registry) all() map[Type]NewCodecFunc {
+	if r != DefaultRegistry {
+		return r.funcs
+	}
+	funcs := make(map[Type]NewCodecFunc, len(r.funcs)+len(NewCodecFuncMap))
+	for typ, f := range NewCodecFuncMap {
+		funcs[typ] = f
+	}
+	for typ, f := range r.funcs {
+		funcs[typ] = f
+	}
+	return funcs
+}
+
+func Register(typ Type, f NewCodecFunc) error {
+	return DefaultRegistry.Register(typ, f)
+}
+
+func Lookup(typ Type) (NewCodecFunc, bool) {
+	return DefaultRegistry.Lookup(typ)
+}
+
+func Types() []Type {
+	return DefaultRegistry.Types()
+}
 
 func init() {
-	NewCodecFuncMap = make(map[Type]NewCodecFunc)
-	NewCodecFuncMap[GobType] = NewGobCodec
+	_ = Register(GobType, NewGobCodec)
+	_ = Register(MsgpackType, NewMsgpackCodec)
 }
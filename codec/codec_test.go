@@ -0,0 +1,48 @@
+package codec
+
+import "testing"
+
+func TestRegistry(t *testing.T) {
+	if _, ok := Lookup(GobType); !ok {
+		t.Fatal("gob codec should be registered by default")
+	}
+	if err := Register(GobType, NewGobCodec); err == nil {
+		t.Fatal("expect duplicate registration to fail")
+	}
+	r := NewRegistry()
+	if err := r.Register("", NewGobCodec); err == nil {
+		t.Fatal("expect empty type to fail")
+	}
+	if err := r.Register("application/x-test", nil); err == nil {
+		t.Fatal("expect nil func to fail")
+	}
+	c := DefaultRegistry.Clone()
+	if err := c.Register("application/x-test", NewGobCodec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Lookup("application/x-test"); ok {
+		t.Fatal("clone should not change the default registry")
+	}
+	if types := c.Types(); len(types) != len(Types())+1 {
+		t.Fatalf("unexpected types %v", types)
+	}
+}
+
+func TestNewCodecFuncMap(t *testing.T) {
+	if NewCodecFuncMap[GobType] == nil || NewCodecFuncMap[MsgpackType] == nil {
+		t.Fatal("NewCodecFuncMap should mirror the default registry")
+	}
+	const legacy Type = "application/x-legacy"
+	NewCodecFuncMap[legacy] = NewGobCodec
+	defer delete(NewCodecFuncMap, legacy)
+	if _, ok := Lookup(legacy); !ok {
+		t.Fatal("codecs added to NewCodecFuncMap should be found")
+	}
+	types := Types()
+	if len(types) != 3 || types[0] != GobType || types[2] != legacy {
+		t.Fatalf("Types should list the legacy codec once, got %v", types)
+	}
+	if _, ok := DefaultRegistry.Clone().Lookup(legacy); !ok {
+		t.Fatal("a clone of DefaultRegistry should keep the legacy codec")
+	}
+}
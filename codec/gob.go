@@ -12,6 +12,8 @@ type GobCodesc struct {
 	dec  *gob.Decoder
 	buf  *bufio.Writer
 	conn io.ReadWriteCloser
+	//使用 net/rpc 的 Request/Response 作为消息头
+	netrpc bool
 }
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
@@ -23,6 +25,14 @@ func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	}
 }
 func (this *GobCodesc) ReadHeader(h *Header) error {
+	if this.netrpc {
+		var nh netrpcHeader
+		if err := this.dec.Decode(&nh); err != nil {
+			return err
+		}
+		*h = Header{ServeiceMethod: nh.ServiceMethod, Seq: nh.Seq, Error: nh.Error}
+		return nil
+	}
 	return this.dec.Decode(h)
 }
 func (this *GobCodesc) ReadBody(b interface{}) error {
@@ -35,13 +45,37 @@ func (this *GobCodesc) Write(h *Header, b interface{}) (err error) {
 			this.Close()
 		}
 	}()
-	if err = this.enc.Encode(h); err != nil {
+	return this.encode(h, b)
+}
+
+// WriteBatch encodes every message before a single flush.
+func (this *GobCodesc) WriteBatch(hs []*Header, bodies []interface{}) (err error) {
+	defer func() {
+		this.buf.Flush()
+		if err != nil {
+			this.Close()
+		}
+	}()
+	for i := range hs {
+		if err = this.encode(hs[i], bodies[i]); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+func (this *GobCodesc) encode(h *Header, b interface{}) error {
+	var hv interface{} = h
+	if this.netrpc {
+		hv = &netrpcHeader{ServiceMethod: h.ServeiceMethod, Seq: h.Seq, Error: h.Error}
+	}
+	if err := this.enc.Encode(hv); err != nil {
 		log.Println("rpc codec: gob error encoding header: ", err)
-		return
+		return err
 	}
-	if err = this.enc.Encode(b); err != nil {
+	if err := this.enc.Encode(b); err != nil {
 		log.Println("rpc codec: gob error encoding body: ", err)
-		return
+		return err
 	}
 	return nil
 }
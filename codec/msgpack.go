@@ -0,0 +1,989 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MsgpackCodec encodes headers and bodies as MessagePack values.
+// Structs are written as maps keyed by field name, the `msgpack:"name,omitempty"`
+// tag renames or skips ("-") a field. time.Time uses the timestamp extension (type -1).
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  *bufio.Writer
+	enc  *msgpackEncoder
+}
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &MsgpackCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  buf,
+		enc:  &msgpackEncoder{w: buf},
+	}
+}
+
+func (this *MsgpackCodec) ReadHeader(h *Header) error {
+	return UnmarshalMsgpackFrom(this.r, h)
+}
+
+func (this *MsgpackCodec) ReadBody(b interface{}) error {
+	if raw, ok := b.(*RawMessage); ok {
+		body, err := this.ReadRawBody()
+		*raw = body
+		return err
+	}
+	return UnmarshalMsgpackFrom(this.r, b)
+}
+
+// ReadRawBody returns the next value exactly as it was encoded.
+func (this *MsgpackCodec) ReadRawBody() (RawMessage, error) {
+	rec := &recordingReader{r: this.r}
+	if err := (&msgpackDecoder{r: rec}).skip(); err != nil {
+		return nil, err
+	}
+	return rec.buf, nil
+}
+
+func (this *MsgpackCodec) WriteRaw(h *Header, body RawMessage) error {
+	return this.Write(h, body)
+}
+
+func (this *MsgpackCodec) Write(h *Header, b interface{}) (err error) {
+	defer func() {
+		this.buf.Flush()
+		if err != nil {
+			this.Close()
+		}
+	}()
+	return this.encode(h, b)
+}
+
+// WriteBatch encodes every message before a single flush.
+func (this *MsgpackCodec) WriteBatch(hs []*Header, bodies []interface{}) (err error) {
+	defer func() {
+		this.buf.Flush()
+		if err != nil {
+			this.Close()
+		}
+	}()
+	for i := range hs {
+		if err = this.encode(hs[i], bodies[i]); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+func (this *MsgpackCodec) encode(h *Header, b interface{}) (err error) {
+	if err = this.enc.encode(reflect.ValueOf(h)); err != nil {
+		log.Println("rpc codec: msgpack error encoding header: ", err)
+		return
+	}
+	if raw, ok := b.(*RawMessage); ok {
+		b = *raw
+	}
+	if raw, ok := b.(RawMessage); ok {
+		if len(raw) == 0 {
+			raw = RawMessage{mpNil}
+		}
+		_, err = this.buf.Write(raw)
+	} else {
+		err = this.enc.encode(reflect.ValueOf(b))
+	}
+	if err != nil {
+		log.Println("rpc codec: msgpack error encoding body: ", err)
+		return
+	}
+	return nil
+}
+
+func (this *MsgpackCodec) Close() error {
+	return this.conn.Close()
+}
+
+// MarshalMsgpack returns the MessagePack encoding of v.
+func MarshalMsgpack(v interface{}) ([]byte, error) {
+	var b byteWriter
+	if err := (&msgpackEncoder{w: &b}).encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// UnmarshalMsgpack decodes one MessagePack value from data into v,
+// which must be a non-nil pointer. A nil v discards the value.
+func UnmarshalMsgpack(data []byte, v interface{}) error {
+	return UnmarshalMsgpackFrom(bufio.NewReader(bytes.NewReader(data)), v)
+}
+
+// UnmarshalMsgpackFrom decodes the next MessagePack value read from r into v.
+func UnmarshalMsgpackFrom(r *bufio.Reader, v interface{}) error {
+	d := &msgpackDecoder{r: r}
+	if v == nil {
+		return d.skip()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: decode into non-pointer %T", v)
+	}
+	return d.decode(rv.Elem())
+}
+
+type byteWriter []byte
+
+func (b *byteWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
+
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpExt8     = 0xc7
+	mpExt16    = 0xc8
+	mpExt32    = 0xc9
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpFixExt1  = 0xd4
+	mpFixExt2  = 0xd5
+	mpFixExt4  = 0xd6
+	mpFixExt8  = 0xd7
+	mpFixExt16 = 0xd8
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+
+	mpTimeExt = -1
+	//单个字符串/二进制/容器的长度上限，防止损坏的数据导致超大内存分配
+	mpMaxLen = 64 << 20
+	//容器嵌套的层数上限，解码是递归的，过深的嵌套会耗尽栈
+	mpMaxDepth = 10000
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type msgpackField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var msgpackFieldCache sync.Map // reflect.Type -> []msgpackField
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if f, ok := msgpackFieldCache.Load(t); ok {
+		return f.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue //未导出字段
+		}
+		name, opts := sf.Name, ""
+		if tag, ok := sf.Tag.Lookup("msgpack"); ok {
+			if tag == "-" {
+				continue
+			}
+			if comma := strings.Index(tag, ","); comma >= 0 {
+				tag, opts = tag[:comma], tag[comma+1:]
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, msgpackField{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+type msgpackEncoder struct {
+	w       io.Writer
+	scratch [9]byte
+}
+
+func (e *msgpackEncoder) writeByte(c byte) error {
+	e.scratch[0] = c
+	_, err := e.w.Write(e.scratch[:1])
+	return err
+}
+
+func (e *msgpackEncoder) writePrefixed(c byte, size int, v uint64) error {
+	e.scratch[0] = c
+	switch size {
+	case 1:
+		e.scratch[1] = byte(v)
+	case 2:
+		binary.BigEndian.PutUint16(e.scratch[1:], uint16(v))
+	case 4:
+		binary.BigEndian.PutUint32(e.scratch[1:], uint32(v))
+	case 8:
+		binary.BigEndian.PutUint64(e.scratch[1:], v)
+	}
+	_, err := e.w.Write(e.scratch[:1+size])
+	return err
+}
+
+func (e *msgpackEncoder) writeLen(fix byte, fixMax int, c8, c16, c32 byte, n int) error {
+	switch {
+	case n <= fixMax:
+		return e.writeByte(fix | byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		return e.writePrefixed(c8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		return e.writePrefixed(c16, 2, uint64(n))
+	case uint64(n) <= math.MaxUint32:
+		return e.writePrefixed(c32, 4, uint64(n))
+	}
+	return errors.New("msgpack: length overflows uint32")
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) error {
+	switch {
+	case i >= 0:
+		return e.encodeUint(uint64(i))
+	case i >= -32:
+		return e.writeByte(byte(i))
+	case i >= math.MinInt8:
+		return e.writePrefixed(mpInt8, 1, uint64(i))
+	case i >= math.MinInt16:
+		return e.writePrefixed(mpInt16, 2, uint64(i))
+	case i >= math.MinInt32:
+		return e.writePrefixed(mpInt32, 4, uint64(i))
+	}
+	return e.writePrefixed(mpInt64, 8, uint64(i))
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) error {
+	switch {
+	case u <= 0x7f:
+		return e.writeByte(byte(u))
+	case u <= math.MaxUint8:
+		return e.writePrefixed(mpUint8, 1, u)
+	case u <= math.MaxUint16:
+		return e.writePrefixed(mpUint16, 2, u)
+	case u <= math.MaxUint32:
+		return e.writePrefixed(mpUint32, 4, u)
+	}
+	return e.writePrefixed(mpUint64, 8, u)
+}
+
+func (e *msgpackEncoder) encodeString(s string) error {
+	if err := e.writeLen(0xa0, 31, mpStr8, mpStr16, mpStr32, len(s)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) error {
+	if err := e.writeLen(0, -1, mpBin8, mpBin16, mpBin32, len(b)); err != nil {
+		return err
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+// encodeTime uses the smallest of the timestamp 32/64/96 layouts.
+func (e *msgpackEncoder) encodeTime(t time.Time) error {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	var data []byte
+	switch {
+	case sec>>32 == 0 && nsec == 0:
+		data = make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(sec))
+	case sec>>34 == 0:
+		data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(nsec)<<34|uint64(sec))
+	default:
+		data = make([]byte, 12)
+		binary.BigEndian.PutUint32(data, uint32(nsec))
+		binary.BigEndian.PutUint64(data[4:], uint64(sec))
+	}
+	var err error
+	if len(data) == 12 {
+		err = e.writePrefixed(mpExt8, 1, 12)
+	} else if len(data) == 4 {
+		err = e.writeByte(mpFixExt4)
+	} else {
+		err = e.writeByte(mpFixExt8)
+	}
+	if err != nil {
+		return err
+	}
+	if err = e.writeByte(0xff); err != nil { //扩展类型 -1
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return e.writeByte(mpNil)
+	}
+	if v.Type() == timeType {
+		return e.encodeTime(v.Interface().(time.Time))
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.writeByte(mpNil)
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return e.writeByte(mpTrue)
+		}
+		return e.writeByte(mpFalse)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.encodeUint(v.Uint())
+	case reflect.Float32:
+		return e.writePrefixed(mpFloat32, 4, uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		return e.writePrefixed(mpFloat64, 8, math.Float64bits(v.Float()))
+	case reflect.String:
+		return e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			return e.writeByte(mpNil)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.encodeBytes(v.Bytes())
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return e.writeByte(mpNil)
+		}
+		if err := e.writeLen(0x80, 15, 0, mpMap16, mpMap32, v.Len()); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	}
+	return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	n := v.Len()
+	if err := e.writeLen(0x90, 15, 0, mpArray16, mpArray32, n); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+	if err := e.writeLen(0x80, 15, 0, mpMap16, mpMap32, n); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if err := e.encodeString(f.name); err != nil {
+			return err
+		}
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type msgpackDecoder struct {
+	r     msgpackReader
+	depth int //正在解码的容器层数
+}
+
+type msgpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// recordingReader keeps a copy of every byte read, used to capture a raw value.
+type recordingReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+func (r *recordingReader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, c)
+	}
+	return c, err
+}
+
+// token is one decoded leading element: a scalar value or the length of a container.
+type token struct {
+	kind byte // 'n' nil, 'b' bool, 'i' int, 'u' uint, 'f' float, 's' str, 'y' bin, 'a' array, 'm' map, 'e' ext
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	data []byte // str、bin、ext 的内容
+	n    int    // array/map 的元素个数
+	ext  int8
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if n < 0 || n > mpMaxLen {
+		return nil, fmt.Errorf("msgpack: length %d out of range", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:size]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b[:])), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b[:])), nil
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *msgpackDecoder) next() (t token, err error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return t, err //值开头的 EOF 是正常结束
+	}
+	var u uint64
+	switch {
+	case c <= 0x7f:
+		return token{kind: 'u', u: uint64(c)}, nil
+	case c >= 0xe0:
+		return token{kind: 'i', i: int64(int8(c))}, nil
+	case c&0xe0 == 0xa0:
+		t.kind = 's'
+		t.data, err = d.readN(int(c & 0x1f))
+		return
+	case c&0xf0 == 0x90:
+		return token{kind: 'a', n: int(c & 0x0f)}, nil
+	case c&0xf0 == 0x80:
+		return token{kind: 'm', n: int(c & 0x0f)}, nil
+	}
+	switch c {
+	case mpNil:
+		t.kind = 'n'
+	case mpFalse, mpTrue:
+		t.kind, t.b = 'b', c == mpTrue
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		t.kind = 'u'
+		t.u, err = d.readUint(1 << (c - mpUint8))
+	case mpInt8, mpInt16, mpInt32, mpInt64:
+		t.kind = 'i'
+		size := 1 << (c - mpInt8)
+		if u, err = d.readUint(size); err == nil {
+			shift := uint(64 - 8*size)
+			t.i = int64(u<<shift) >> shift
+		}
+	case mpFloat32:
+		t.kind = 'f'
+		if u, err = d.readUint(4); err == nil {
+			t.f = float64(math.Float32frombits(uint32(u)))
+		}
+	case mpFloat64:
+		t.kind = 'f'
+		if u, err = d.readUint(8); err == nil {
+			t.f = math.Float64frombits(u)
+		}
+	case mpStr8, mpStr16, mpStr32, mpBin8, mpBin16, mpBin32:
+		t.kind = 's'
+		size := 1 << (c - mpStr8)
+		if c <= mpBin32 {
+			t.kind = 'y'
+			size = 1 << (c - mpBin8)
+		}
+		if u, err = d.readUint(size); err == nil {
+			t.data, err = d.readN(int(u))
+		}
+	case mpArray16, mpArray32, mpMap16, mpMap32:
+		t.kind = 'a'
+		if c >= mpMap16 {
+			t.kind = 'm'
+		}
+		size := 2
+		if c == mpArray32 || c == mpMap32 {
+			size = 4
+		}
+		if u, err = d.readUint(size); err == nil {
+			if u > mpMaxLen {
+				return t, fmt.Errorf("msgpack: length %d out of range", u)
+			}
+			t.n = int(u)
+		}
+	case mpFixExt1, mpFixExt2, mpFixExt4, mpFixExt8, mpFixExt16, mpExt8, mpExt16, mpExt32:
+		t.kind = 'e'
+		n := 1 << (c - mpFixExt1)
+		if c < mpFixExt1 {
+			if u, err = d.readUint(1 << (c - mpExt8)); err != nil {
+				return
+			}
+			n = int(u)
+		}
+		if u, err = d.readUint(1); err != nil {
+			return
+		}
+		t.ext = int8(u)
+		t.data, err = d.readN(n)
+	default:
+		err = fmt.Errorf("msgpack: invalid code 0x%x", c)
+	}
+	if err != nil {
+		err = unexpectedEOF(err)
+	}
+	return
+}
+
+// skip discards the next value.
+func (d *msgpackDecoder) skip() error {
+	t, err := d.next()
+	if err != nil {
+		return err
+	}
+	return d.skipRest(t)
+}
+
+// skipRest discards the elements of t. It counts the values left instead of
+// recursing, so any nesting depth can be skipped.
+func (d *msgpackDecoder) skipRest(t token) error {
+	n := 0
+	for {
+		switch t.kind {
+		case 'a':
+			n += t.n
+		case 'm':
+			n += 2 * t.n
+		}
+		if n == 0 {
+			return nil
+		}
+		n--
+		var err error
+		if t, err = d.next(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	t, err := d.next()
+	if err != nil {
+		return err
+	}
+	if d.depth >= mpMaxDepth {
+		if err := d.skipRest(t); err != nil {
+			return err
+		}
+		return fmt.Errorf("msgpack: exceeded max depth %d", mpMaxDepth)
+	}
+	d.depth++
+	err = d.decodeToken(t, v)
+	d.depth--
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeToken(t token, v reflect.Value) error {
+	if t.kind == 'n' {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Type() == timeType {
+		tm, err := decodeTime(t)
+		if err != nil {
+			return d.discard(t, err)
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeToken(t, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.discard(t, fmt.Errorf("msgpack: can't decode into non-empty interface %s", v.Type()))
+		}
+		x, err := d.decodeInterface(t)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Bool:
+		if t.kind != 'b' {
+			break
+		}
+		v.SetBool(t.b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch t.kind {
+		case 'i':
+			i = t.i
+		case 'u':
+			if t.u > math.MaxInt64 {
+				return fmt.Errorf("msgpack: %d overflows %s", t.u, v.Type())
+			}
+			i = int64(t.u)
+		default:
+			return d.mismatch(t, v)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("msgpack: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch {
+		case t.kind == 'u':
+			u = t.u
+		case t.kind == 'i' && t.i >= 0:
+			u = uint64(t.i)
+		default:
+			return d.mismatch(t, v)
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch t.kind {
+		case 'f':
+			v.SetFloat(t.f)
+		case 'i':
+			v.SetFloat(float64(t.i))
+		case 'u':
+			v.SetFloat(float64(t.u))
+		default:
+			return d.mismatch(t, v)
+		}
+		return nil
+	case reflect.String:
+		if t.kind != 's' && t.kind != 'y' {
+			break
+		}
+		v.SetString(string(t.data))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (t.kind == 's' || t.kind == 'y') {
+			v.SetBytes(append([]byte(nil), t.data...))
+			return nil
+		}
+		if t.kind != 'a' {
+			break
+		}
+		s := reflect.MakeSlice(v.Type(), 0, minInt(t.n, 1024))
+		for i := 0; i < t.n; i++ {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(elem); err != nil {
+				return d.fail(err, t.n-i-1)
+			}
+			s = reflect.Append(s, elem)
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		if t.kind != 'a' {
+			break
+		}
+		v.Set(reflect.Zero(v.Type()))
+		for i := 0; i < t.n; i++ {
+			if i >= v.Len() {
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i)); err != nil {
+				return d.fail(err, t.n-i-1)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if t.kind != 'm' {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < t.n; i++ {
+			key, val := reflect.New(kt).Elem(), reflect.New(vt).Elem()
+			if err := d.decode(key); err != nil {
+				return d.fail(err, 2*(t.n-i)-1)
+			}
+			if err := d.decode(val); err != nil {
+				return d.fail(err, 2*(t.n-i-1))
+			}
+			v.SetMapIndex(key, val)
+		}
+		return nil
+	case reflect.Struct:
+		if t.kind != 'm' {
+			break
+		}
+		return d.decodeStruct(t.n, v)
+	}
+	return d.mismatch(t, v)
+}
+
+func (d *msgpackDecoder) decodeStruct(n int, v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+	for i := 0; i < n; i++ {
+		var name string
+		if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return d.fail(err, 2*(n-i)-1)
+		}
+		var field *msgpackField
+		for j := range fields {
+			if fields[j].name == name {
+				field = &fields[j]
+				break
+			}
+		}
+		if field == nil {
+			for j := range fields {
+				if strings.EqualFold(fields[j].name, name) {
+					field = &fields[j]
+					break
+				}
+			}
+		}
+		if field == nil {
+			//未知字段直接跳过，方便服务端和客户端各自演进
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Field(field.index)); err != nil {
+			return d.fail(err, 2*(n-i-1))
+		}
+	}
+	return nil
+}
+
+// decodeInterface builds the generic Go value for t: int64, uint64, float64, string,
+// []byte, []interface{}, map[string]interface{} (map[interface{}]interface{} when a
+// key isn't a string) or time.Time.
+func (d *msgpackDecoder) decodeInterface(t token) (interface{}, error) {
+	switch t.kind {
+	case 'n':
+		return nil, nil
+	case 'b':
+		return t.b, nil
+	case 'i':
+		return t.i, nil
+	case 'u':
+		return t.u, nil
+	case 'f':
+		return t.f, nil
+	case 's':
+		return string(t.data), nil
+	case 'y':
+		return t.data, nil
+	case 'e':
+		return decodeTime(t)
+	case 'a':
+		s := make([]interface{}, 0, minInt(t.n, 1024))
+		for i := 0; i < t.n; i++ {
+			var x interface{}
+			if err := d.decode(reflect.ValueOf(&x).Elem()); err != nil {
+				return nil, d.fail(err, t.n-i-1)
+			}
+			s = append(s, x)
+		}
+		return s, nil
+	}
+	//长度来自对端，不能直接按它分配
+	keys := make([]interface{}, 0, minInt(t.n, 1024))
+	vals := make([]interface{}, 0, minInt(t.n, 1024))
+	stringKeys := true
+	for i := 0; i < t.n; i++ {
+		var key, val interface{}
+		if err := d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+			return nil, d.fail(err, 2*(t.n-i)-1)
+		}
+		if err := d.decode(reflect.ValueOf(&val).Elem()); err != nil {
+			return nil, d.fail(err, 2*(t.n-i-1))
+		}
+		keys, vals = append(keys, key), append(vals, val)
+		_, ok := key.(string)
+		stringKeys = stringKeys && ok
+	}
+	if stringKeys {
+		m := make(map[string]interface{}, len(keys))
+		for i := range keys {
+			m[keys[i].(string)] = vals[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, len(keys))
+	for i := range keys {
+		if keys[i] != nil && !reflect.TypeOf(keys[i]).Comparable() {
+			return nil, fmt.Errorf("msgpack: unhashable map key %T", keys[i])
+		}
+		m[keys[i]] = vals[i]
+	}
+	return m, nil
+}
+
+func decodeTime(t token) (time.Time, error) {
+	if t.kind != 'e' || t.ext != mpTimeExt {
+		return time.Time{}, fmt.Errorf("msgpack: can't decode %s into time.Time", tokenName(t))
+	}
+	switch len(t.data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(t.data)), 0), nil
+	case 8:
+		u := binary.BigEndian.Uint64(t.data)
+		return time.Unix(int64(u&(1<<34-1)), int64(u>>34)), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(t.data)
+		sec := binary.BigEndian.Uint64(t.data[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+	return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", len(t.data))
+}
+
+// mismatch skips what is left of the value so the stream stays in sync.
+func (d *msgpackDecoder) mismatch(t token, v reflect.Value) error {
+	return d.discard(t, fmt.Errorf("msgpack: can't decode %s into %s", tokenName(t), v.Type()))
+}
+
+// discard skips the elements of t and returns err, or the read error hit while skipping.
+func (d *msgpackDecoder) discard(t token, err error) error {
+	if skipErr := d.skipRest(t); skipErr != nil {
+		return skipErr
+	}
+	return err
+}
+
+// fail skips the n values left in the container whose element failed with err,
+// so that a decode error always leaves the stream after the whole value.
+func (d *msgpackDecoder) fail(err error, n int) error {
+	return d.discard(token{kind: 'a', n: n}, err)
+}
+
+func tokenName(t token) string {
+	switch t.kind {
+	case 'b':
+		return "bool"
+	case 'i', 'u':
+		return "integer"
+	case 'f':
+		return "float"
+	case 's':
+		return "string"
+	case 'y':
+		return "binary"
+	case 'a':
+		return "array"
+	case 'm':
+		return "map"
+	case 'e':
+		return fmt.Sprintf("extension %d", t.ext)
+	}
+	return "nil"
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
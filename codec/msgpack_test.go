@@ -0,0 +1,175 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type msgpackItem struct {
+	Name    string            `msgpack:"name"`
+	Count   int64             `msgpack:"count"`
+	Ratio   float64           `msgpack:"ratio,omitempty"`
+	Tags    []string          `msgpack:"tags"`
+	Attrs   map[string]uint16 `msgpack:"attrs"`
+	At      time.Time         `msgpack:"at"`
+	Next    *msgpackItem      `msgpack:"next"`
+	Blob    []byte            `msgpack:"blob"`
+	Skipped string            `msgpack:"-"`
+}
+
+func TestMsgpackWireFormat(t *testing.T) {
+	data, err := MarshalMsgpack(map[string]interface{}{"a": 1})
+	if err != nil || !bytes.Equal(data, []byte{0x81, 0xa1, 'a', 0x01}) {
+		t.Fatalf("unexpected encoding % x, %v", data, err)
+	}
+	data, _ = MarshalMsgpack([]int64{-1, 200, -200, 1 << 40})
+	want := []byte{0x94, 0xff, 0xcc, 0xc8, 0xd1, 0xff, 0x38, 0xcf, 0, 0, 1, 0, 0, 0, 0, 0}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("unexpected encoding % x", data)
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	in := msgpackItem{
+		Name:    "foo",
+		Count:   1<<53 + 1,
+		Tags:    []string{"x", "y"},
+		Attrs:   map[string]uint16{"port": 9999},
+		At:      time.Unix(1700000000, 123456789),
+		Next:    &msgpackItem{Name: "bar", At: time.Unix(-10, 0)},
+		Blob:    []byte{1, 2, 3},
+		Skipped: "lost",
+	}
+	data, err := MarshalMsgpack(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out msgpackItem
+	if err = UnmarshalMsgpack(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !out.At.Equal(in.At) || !out.Next.At.Equal(in.Next.At) {
+		t.Fatalf("time mismatch: %v %v", out.At, out.Next.At)
+	}
+	out.At, out.Next.At = in.At, in.Next.At
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+
+	var generic interface{}
+	if err = UnmarshalMsgpack(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	m := generic.(map[string]interface{})
+	if m["count"] != uint64(1<<53+1) || m["name"] != "foo" {
+		t.Fatalf("unexpected generic value %v", m)
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	var raw bufferConn
+	cc := NewMsgpackCodec(&raw)
+	_ = cc.Write(&Header{ServeiceMethod: "Foo.Sum", Seq: 7}, []int{1, 2})
+	_ = cc.Write(&Header{ServeiceMethod: "Foo.Sum", Seq: 8}, "skip me")
+	var h Header
+	var body []int
+	if err := cc.ReadHeader(&h); err != nil || h.Seq != 7 {
+		t.Fatalf("read header: %v %+v", err, h)
+	}
+	if err := cc.ReadBody(&body); err != nil || len(body) != 2 {
+		t.Fatalf("read body: %v %v", err, body)
+	}
+	_ = cc.ReadHeader(&h)
+	if err := cc.ReadBody(nil); err != nil || h.Seq != 8 {
+		t.Fatalf("skip body: %v", err)
+	}
+}
+
+func TestMsgpackMismatchResync(t *testing.T) {
+	type pair struct{ A, B int }
+	values := []interface{}{
+		map[string]interface{}{"A": "x", "B": 2},
+		[]interface{}{1, "x", []int{3, 4}, map[string]int{"c": 5}},
+		map[string]interface{}{"A": map[string]interface{}{"A": "x", "B": []int{1}}, "B": 2},
+		//{[1]: 1, "k": 2}，键不可哈希
+		RawMessage{0x82, 0x91, 0x01, 0x01, 0xa1, 'k', 0x02},
+	}
+	targets := []func() interface{}{
+		func() interface{} { return new(pair) },
+		func() interface{} { return new([]int) },
+		func() interface{} { return new(map[string]pair) },
+		func() interface{} { return new(interface{}) },
+	}
+	for i, value := range values {
+		var raw bufferConn
+		cc := NewMsgpackCodec(&raw)
+		body, ok := value.(RawMessage)
+		if !ok {
+			body, _ = MarshalMsgpack(value)
+		}
+		_ = cc.(RawCodec).WriteRaw(&Header{Seq: 1}, body)
+		_ = cc.Write(&Header{Seq: 2}, pair{1, 2})
+		var h Header
+		_ = cc.ReadHeader(&h)
+		if err := cc.ReadBody(targets[i]()); err == nil {
+			t.Fatalf("decode %v into %T should fail", value, targets[i]())
+		}
+		//出错后流仍停在下一帧的开头
+		var next pair
+		if err := cc.ReadHeader(&h); err != nil || h.Seq != 2 {
+			t.Fatalf("header after %v: %v %+v", value, err, h)
+		}
+		if err := cc.ReadBody(&next); err != nil || next != (pair{1, 2}) {
+			t.Fatalf("body after %v: %v %+v", value, err, next)
+		}
+	}
+}
+
+func TestMsgpackTruncatedHugeHeader(t *testing.T) {
+	//map32/array32 头声明 64M 个元素，但后面没有数据
+	headers := [][]byte{{0xdf, 0x04, 0, 0, 0}, {0xdd, 0x04, 0, 0, 0}}
+	targets := []func() interface{}{
+		func() interface{} { return new(interface{}) },
+		func() interface{} { return new(map[string]int) },
+		func() interface{} { return new([]int) },
+	}
+	for _, data := range headers {
+		for _, target := range targets {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			v := target()
+			if err := UnmarshalMsgpack(data, v); err == nil {
+				t.Fatalf("% x into %T should fail", data, v)
+			}
+			runtime.ReadMemStats(&after)
+			if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+				t.Fatalf("% x into %T allocated %d bytes", data, v, n)
+			}
+		}
+	}
+}
+
+func TestMsgpackMaxDepth(t *testing.T) {
+	//层层嵌套的单元素数组，最里面是 nil
+	deep := append(bytes.Repeat([]byte{0x91}, 4<<20), 0xc0)
+	var x interface{}
+	if err := UnmarshalMsgpack(deep, &x); err == nil || !strings.Contains(err.Error(), "max depth") {
+		t.Fatalf("expect max depth error, got %v", err)
+	}
+	var s []interface{}
+	if err := UnmarshalMsgpack(deep, &s); err == nil {
+		t.Fatal("decoding into []interface{} should fail too")
+	}
+	if err := UnmarshalMsgpack(deep, nil); err != nil {
+		t.Fatalf("skip should handle any depth: %v", err)
+	}
+	shallow := append(bytes.Repeat([]byte{0x91}, 100), 0x01)
+	if err := UnmarshalMsgpack(shallow, &x); err != nil {
+		t.Fatalf("decode 100 levels: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package codec
+
+import "io"
+
+// netrpcHeader has the fields of net/rpc's Request and Response,
+// gob matches fields by name so it decodes and encodes both.
+type netrpcHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+// NewNetRPCCodec returns a gob codec speaking the protocol of Go's standard
+// net/rpc package, which only differs by the header layout.
+// That protocol has no batches, Header.Batch is never sent.
+func NewNetRPCCodec(conn io.ReadWriteCloser) Codec {
+	c := NewGobCodec(conn).(*GobCodesc)
+	c.netrpc = true
+	return c
+}
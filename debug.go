@@ -3,6 +3,7 @@ package myrpc
 import (
 	"fmt"
 	"html/template"
+	"myrpc/codec"
 	"net/http"
 )
 
@@ -13,7 +14,8 @@ type debugHTTP struct {
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	Codecs: {{range .Codecs}}{{.}} {{end}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
@@ -21,7 +23,7 @@ const debugText = `<html>
 		<th align=center>Method</th><th align=center>Calls</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}{{$mtype.Signature}}</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
 			</tr>
 		{{end}}
@@ -32,6 +34,29 @@ const debugText = `<html>
 
 var debug = template.Must(template.New("RPC debug").Parse(debugText))
 
+// Signature returns the parameters and results of the method as declared,
+// e.g. "(context.Context, myrpc.Args) (int, error)".
+func (m *methodType) Signature() string {
+	ctx := ""
+	if m.withCtx {
+		ctx = "context.Context, "
+	}
+	if m.returns {
+		return fmt.Sprintf("(%s%s) (%s, error)", ctx, m.ArgType, m.ReplyType.Elem())
+	}
+	return fmt.Sprintf("(%s%s, %s) error", ctx, m.ArgType, m.ReplyType)
+}
+
+// DebugHandler serves an HTML page listing the services and their call counts.
+func (server *Server) DebugHandler() http.Handler {
+	return &debugHTTP{server}
+}
+
+type debugPage struct {
+	Codecs   []codec.Type
+	Services []debugService
+}
+
 type debugService struct {
 	Name   string
 	Method map[string]*methodType
@@ -44,7 +69,7 @@ func (server *debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		services = append(services, debugService{namei.(string), svc.method})
 		return true
 	})
-	err := debug.Execute(w, services) //把模板里的变量替换掉
+	err := debug.Execute(w, debugPage{server.codecs().Types(), services}) //把模板里的变量替换掉
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
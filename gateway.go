@@ -0,0 +1,102 @@
+package myrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type gatewayHTTP struct {
+	*Server
+}
+
+// GatewayHandler exposes every registered method as POST /{Service}/{Method},
+// the JSON body is decoded into the method's argument and the reply is sent back
+// as JSON. Errors are answered with {"error":{"code":...,"message":...}} using
+// the JSON-RPC codes, and an HTTP status derived from that code.
+// The last two path segments are used, so the handler can be mounted under any prefix.
+func (server *Server) GatewayHandler() http.Handler {
+	return &gatewayHTTP{server}
+}
+
+// gatewayStatus maps error codes to HTTP statuses.
+var gatewayStatus = map[int]int{
+	jsonrpcParseError:     http.StatusBadRequest,
+	jsonrpcInvalidRequest: http.StatusBadRequest,
+	jsonrpcMethodNotFound: http.StatusNotFound,
+	jsonrpcInvalidParams:  http.StatusBadRequest,
+	jsonrpcInternalError:  http.StatusInternalServerError,
+	jsonrpcServerError:    http.StatusInternalServerError,
+}
+
+func (server *gatewayHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayError(w, &JSONRPCError{Code: jsonrpcInvalidRequest, Message: "405 must POST"}, http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		writeGatewayError(w, &JSONRPCError{Code: jsonrpcMethodNotFound, Message: "path must be /{Service}/{Method}"}, 0)
+		return
+	}
+	serviceMethod := parts[len(parts)-2] + "." + parts[len(parts)-1]
+	body, status, err := server.readBody(w, req)
+	if err != nil {
+		writeGatewayError(w, &JSONRPCError{Code: jsonrpcInvalidRequest, Message: err.Error()}, status)
+		return
+	}
+	if err = server.validateGatewayBody(serviceMethod, body); err != nil {
+		writeGatewayError(w, &JSONRPCError{Code: errorCode(err), Message: err.Error()}, 0)
+		return
+	}
+	result, err := server.callJSON(req.Context(), serviceMethod, body, decodeGatewayBody)
+	if err != nil {
+		writeGatewayError(w, &JSONRPCError{Code: errorCode(err), Message: err.Error()}, 0)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(result)
+}
+
+// validateGatewayBody checks a non empty body against the argument's schema,
+// unknown methods are left for callJSON to report.
+func (server *Server) validateGatewayBody(serviceMethod string, body []byte) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	ms, err := server.methodSchema(serviceMethod)
+	if err != nil {
+		return nil
+	}
+	if err = ms.Arg.Validate(body); err != nil {
+		return fmt.Errorf("%w %v", errInvalidParams, err)
+	}
+	return nil
+}
+
+// decodeGatewayBody rejects unknown fields, an empty body leaves the argument at its zero value.
+func decodeGatewayBody(body json.RawMessage, argvi interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	return dec.Decode(argvi)
+}
+
+// writeGatewayError derives the status from the code when status is zero.
+func writeGatewayError(w http.ResponseWriter, e *JSONRPCError, status int) {
+	if status == 0 {
+		if status = gatewayStatus[e.Code]; status == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error *JSONRPCError `json:"error"`
+	}{e})
+}
@@ -0,0 +1,323 @@
+package myrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// JSON-RPC 2.0 standard error codes, method errors use jsonrpcServerError.
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+	jsonrpcServerError    = -32000
+)
+
+const jsonrpcVersion = "2.0"
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` //没有 id 的请求是通知，不需要响应
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// JSONRPCError is the error object of a JSON-RPC 2.0 response.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return e.Message
+}
+
+func jsonrpcErrorResponse(id json.RawMessage, code int, msg string) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: jsonrpcVersion, ID: id, Error: &JSONRPCError{Code: code, Message: msg}}
+}
+
+// handleJSONRPC serves one JSON-RPC message, a request or a batch of requests,
+// and returns the encoded response, nil if nothing must be sent back.
+func (server *Server) handleJSONRPC(ctx context.Context, msg json.RawMessage) []byte {
+	var resp interface{}
+	msg = bytes.TrimSpace(msg)
+	if len(msg) > 0 && msg[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(msg, &batch); err != nil || len(batch) == 0 {
+			resp = jsonrpcErrorResponse(nil, jsonrpcInvalidRequest, "invalid request")
+		} else {
+			var resps []*jsonrpcResponse
+			for _, m := range batch {
+				if r := server.callJSONRPC(ctx, m); r != nil {
+					resps = append(resps, r)
+				}
+			}
+			if len(resps) == 0 {
+				return nil
+			}
+			resp = resps
+		}
+	} else if r := server.callJSONRPC(ctx, msg); r != nil {
+		resp = r
+	} else {
+		return nil
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("rpc server: jsonrpc encode response error:", err)
+		data, _ = json.Marshal(jsonrpcErrorResponse(nil, jsonrpcInternalError, err.Error()))
+	}
+	return data
+}
+
+func (server *Server) callJSONRPC(ctx context.Context, msg json.RawMessage) *jsonrpcResponse {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return jsonrpcErrorResponse(nil, jsonrpcInvalidRequest, "invalid request")
+	}
+	if req.Version != jsonrpcVersion || req.Method == "" {
+		return jsonrpcErrorResponse(req.ID, jsonrpcInvalidRequest, "invalid request")
+	}
+	resp := server.dispatchJSONRPC(ctx, &req)
+	if req.ID == nil {
+		return nil
+	}
+	return resp
+}
+
+func (server *Server) dispatchJSONRPC(ctx context.Context, req *jsonrpcRequest) *jsonrpcResponse {
+	result, err := server.callJSON(ctx, req.Method, req.Params, decodeJSONRPCParams)
+	if err != nil {
+		return jsonrpcErrorResponse(req.ID, errorCode(err), err.Error())
+	}
+	return &jsonrpcResponse{Version: jsonrpcVersion, Result: result, ID: req.ID}
+}
+
+// errInvalidParams marks arguments that couldn't be decoded.
+var errInvalidParams = errors.New("invalid params:")
+
+// errorCode classifies an error returned by callJSON into a JSON-RPC error code.
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrIllFormed), errors.Is(err, ErrServiceNotFound), errors.Is(err, ErrMethodNotFound):
+		return jsonrpcMethodNotFound
+	case errors.Is(err, errInvalidParams):
+		return jsonrpcInvalidParams
+	case errors.Is(err, errInternal):
+		return jsonrpcInternalError
+	}
+	return jsonrpcServerError
+}
+
+var errInternal = errors.New("rpc server: internal error:")
+
+// callJSON decodes params into the argument of serviceMethod, calls it with ctx
+// and returns the JSON encoded reply.
+func (server *Server) callJSON(ctx context.Context, serviceMethod string, params json.RawMessage, decode func(json.RawMessage, interface{}) error) (json.RawMessage, error) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err == nil && svc.raw != nil {
+		err = fmt.Errorf("%w %s is a raw service", ErrMethodNotFound, serviceMethod)
+	}
+	if err != nil {
+		return nil, err
+	}
+	argv, replyv := mtype.getArgV(), mtype.getReplyV()
+	defer mtype.putArgV(argv)
+	defer mtype.putReplyV(replyv)
+	argvi := argv.Interface()
+	if argv.Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err = decode(params, argvi); err != nil {
+		return nil, fmt.Errorf("%w %v", errInvalidParams, err)
+	}
+	err = svc.callContext(ctx, mtype, argv, replyv)
+	for {
+		next, m, ok := server.redispatch(err, svc, mtype, serviceMethod)
+		if !ok {
+			break
+		}
+		svc, mtype = next, m
+		err = svc.callContext(ctx, mtype, argv, replyv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result, err := json.Marshal(replyv.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("%w %v", errInternal, err)
+	}
+	return result, nil
+}
+
+// decodeJSONRPCParams accepts the argument itself or a positional array holding it,
+// missing params leave the argument at its zero value. An array given for a slice
+// or array argument is the argument itself, unless only its single element fits.
+func decodeJSONRPCParams(params json.RawMessage, argvi interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if params[0] != '[' {
+		return json.Unmarshal(params, argvi)
+	}
+	var positional []json.RawMessage
+	if err := json.Unmarshal(params, &positional); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(argvi).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		err := json.Unmarshal(params, argvi)
+		if err != nil && len(positional) == 1 {
+			return json.Unmarshal(positional[0], argvi)
+		}
+		return err
+	}
+	switch len(positional) {
+	case 0:
+		return nil
+	case 1:
+		return json.Unmarshal(positional[0], argvi)
+	default:
+		return json.Unmarshal(params, argvi)
+	}
+}
+
+// ServeJSONRPC serves JSON-RPC 2.0 messages read from conn until it's closed,
+// requests are handled concurrently and answered in completion order.
+// Shutdown waits for the calls of a conn that is a net.Conn, as it does for ServerCon.
+func (server *Server) ServeJSONRPC(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	if con, ok := conn.(net.Conn); ok {
+		if !server.trackConn(con, true) {
+			return
+		}
+		defer server.trackConn(con, false)
+	}
+	dec := json.NewDecoder(conn)
+	var sending sync.Mutex
+	var wg sync.WaitGroup
+	write := func(data []byte) {
+		sending.Lock()
+		defer sending.Unlock()
+		if _, err := conn.Write(append(data, '\n')); err != nil {
+			log.Println("rpc server: jsonrpc write response error:", err)
+		}
+	}
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				//无法定位下一条消息的起点，回复后断开连接
+				data, _ := json.Marshal(jsonrpcErrorResponse(nil, jsonrpcParseError, "parse error"))
+				write(data)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data := server.handleJSONRPC(context.Background(), msg); data != nil {
+				write(data)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// AcceptJSONRPC serves JSON-RPC 2.0 on every connection accepted from lis.
+func (server *Server) AcceptJSONRPC(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
+	for {
+		con, err := lis.Accept()
+		if err != nil {
+			if !server.shuttingDown() {
+				log.Println("rpc server: accept error:", err)
+			}
+			return
+		}
+		go server.ServeJSONRPC(con)
+	}
+}
+
+func AcceptJSONRPC(lis net.Listener) {
+	DefaultServer.AcceptJSONRPC(lis)
+}
+
+type jsonrpcHTTP struct {
+	*Server
+}
+
+// DefaultMaxBodyBytes limits the HTTP request bodies when Server.MaxBodyBytes is 0.
+const DefaultMaxBodyBytes = 4 << 20
+
+// readBody reads the body of req up to MaxBodyBytes, the status tells 413 from
+// other read errors.
+func (server *Server) readBody(w http.ResponseWriter, req *http.Request) ([]byte, int, error) {
+	limit := server.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, limit))
+	if err == nil {
+		return body, 0, nil
+	}
+	if int64(len(body)) >= limit {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", limit)
+	}
+	return nil, http.StatusBadRequest, err
+}
+
+// JSONRPCHandler serves JSON-RPC 2.0 requests POSTed over HTTP.
+func (server *Server) JSONRPCHandler() http.Handler {
+	return &jsonrpcHTTP{server}
+}
+
+func (server *jsonrpcHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 must POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, status, err := server.readBody(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	var data []byte
+	if !json.Valid(body) {
+		data, _ = json.Marshal(jsonrpcErrorResponse(nil, jsonrpcParseError, "parse error"))
+	} else if data = server.handleJSONRPC(req.Context(), body); data == nil {
+		//只有通知，没有需要返回的内容
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
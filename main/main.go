@@ -40,7 +40,8 @@ func startServer(wg *sync.WaitGroup, registryAddr string) {
 	server := myrpc.NewServer()
 	server.Register(&foo)
 	lfd, _ := net.Listen("tcp", ":0")
-	registry.HeartBeat(registryAddr, "tcp@"+lfd.Addr().String(), 0)
+	addr, _ := server.Advertise("tcp@" + lfd.Addr().String()) //带上提供的服务，便于按服务版本选择实例
+	registry.HeartBeat(registryAddr, addr, 0)
 	wg.Done()
 	server.Accept(lfd)
 }
@@ -87,8 +88,9 @@ func broadcast(registry string) {
 		go func(i int) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,311 @@
+package myrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The in-memory transport connects clients and servers of the same process
+// without sockets. A MemListener is registered under a name and reached with
+// Dial("mem", name) or XDial("mem@name"), every connection is a pair of
+// buffered pipes holding at most capacity bytes in each direction.
+
+const (
+	defaultMemCapacity = 64 << 10
+	memBacklog         = 128 //尚未被 Accept 的连接数上限
+)
+
+var (
+	memMu        sync.Mutex
+	memListeners = make(map[string]*MemListener)
+)
+
+type memAddr string
+
+func (memAddr) Network() string  { return "mem" }
+func (a memAddr) String() string { return string(a) }
+
+type MemListener struct {
+	name     string
+	capacity int
+	conns    chan net.Conn
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex //保护 closed，入队的连接不能晚于 Close 的清理
+	closed   bool
+}
+
+// ListenMem registers an in-memory listener, capacity is the buffer size
+// of each direction of its connections, 0 means 64KB.
+func ListenMem(name string, capacity int) (*MemListener, error) {
+	if capacity <= 0 {
+		capacity = defaultMemCapacity
+	}
+	memMu.Lock()
+	defer memMu.Unlock()
+	if _, dup := memListeners[name]; dup {
+		return nil, errors.New("rpc mem: address already in use: " + name)
+	}
+	l := &MemListener{name: name, capacity: capacity, conns: make(chan net.Conn, memBacklog), done: make(chan struct{})}
+	memListeners[name] = l
+	return l, nil
+}
+
+func (l *MemListener) Accept() (net.Conn, error) {
+	select {
+	case con := <-l.conns:
+		return con, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *MemListener) Close() error {
+	l.once.Do(func() {
+		memMu.Lock()
+		delete(memListeners, l.name)
+		memMu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.closed = true
+		close(l.done)
+		l.drain()
+	})
+	return nil
+}
+
+// drain closes the connections not accepted yet, their dialers read EOF.
+func (l *MemListener) drain() {
+	for {
+		select {
+		case con := <-l.conns:
+			con.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (l *MemListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
+
+// DialMemConn connects to the in-memory listener registered under name.
+func DialMemConn(name string, timeout time.Duration) (net.Conn, error) {
+	memMu.Lock()
+	l := memListeners[name]
+	memMu.Unlock()
+	if l == nil {
+		return nil, errors.New("rpc mem: connection refused: " + name)
+	}
+	return l.dial(timeout)
+}
+
+// dial queues a new connection for Accept, l may have been closed since it was looked up.
+func (l *MemListener) dial(timeout time.Duration) (net.Conn, error) {
+	name := l.name
+	toServer, toClient := newMemPipe(l.capacity), newMemPipe(l.capacity)
+	local, remote := memAddr(name+".client"), memAddr(name)
+	client := &memConn{r: toClient, w: toServer, local: local, remote: remote}
+	server := &memConn{r: toServer, w: toClient, local: remote, remote: local}
+	refused := errors.New("rpc mem: connection refused: " + name)
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, refused
+	}
+	select {
+	case l.conns <- server:
+		l.mu.Unlock()
+		return client, nil
+	default:
+	}
+	l.mu.Unlock()
+
+	//积压已满，不持有锁等待 Accept 腾出位置
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case l.conns <- server:
+	case <-l.done:
+		return nil, refused
+	case <-expired:
+		return nil, os.ErrDeadlineExceeded
+	}
+	//入队时 Close 可能已经清理过积压，这条连接不会再被 Accept
+	l.mu.Lock()
+	closed := l.closed
+	if closed {
+		l.drain()
+	}
+	l.mu.Unlock()
+	if closed {
+		client.Close()
+		return nil, refused
+	}
+	return client, nil
+}
+
+// memPipe is a bounded byte queue with one reading and one writing end.
+type memPipe struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	capacity int
+	wclosed  bool //写端关闭，读完剩余数据后返回 EOF
+	rclosed  bool //读端关闭，写入返回错误
+}
+
+func newMemPipe(capacity int) *memPipe {
+	p := &memPipe{capacity: capacity}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// wait blocks until woken up, deadline wakes it up as well.
+func (p *memPipe) wait(deadline time.Time) error {
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.AfterFunc(d, func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	p.cond.Wait()
+	return nil
+}
+
+func (p *memPipe) read(b []byte, deadline func() time.Time) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 {
+		switch {
+		case p.rclosed:
+			return 0, net.ErrClosed
+		case p.wclosed:
+			return 0, io.EOF
+		}
+		if err := p.wait(deadline()); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	p.cond.Broadcast()
+	return n, nil
+}
+
+func (p *memPipe) write(b []byte, deadline func() time.Time) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		switch {
+		case p.wclosed:
+			return written, net.ErrClosed
+		case p.rclosed:
+			return written, io.ErrClosedPipe
+		}
+		space := p.capacity - len(p.buf)
+		if space <= 0 {
+			if err := p.wait(deadline()); err != nil {
+				return written, err
+			}
+			continue
+		}
+		if space > len(b)-written {
+			space = len(b) - written
+		}
+		p.buf = append(p.buf, b[written:written+space]...)
+		written += space
+		p.cond.Broadcast()
+	}
+	return written, nil
+}
+
+func (p *memPipe) close(reader bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reader {
+		p.rclosed = true
+	} else {
+		p.wclosed = true
+	}
+	p.cond.Broadcast()
+}
+
+type memConn struct {
+	r, w          *memPipe
+	local, remote memAddr
+	mu            sync.Mutex
+	rdeadline     time.Time
+	wdeadline     time.Time
+}
+
+func (c *memConn) Read(b []byte) (int, error) {
+	return c.r.read(b, c.readDeadline)
+}
+
+func (c *memConn) Write(b []byte) (int, error) {
+	return c.w.write(b, c.writeDeadline)
+}
+
+func (c *memConn) Close() error {
+	c.r.close(true)
+	c.w.close(false)
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr  { return c.local }
+func (c *memConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *memConn) readDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rdeadline
+}
+
+func (c *memConn) writeDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wdeadline
+}
+
+func (c *memConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline also wakes up a blocked Read so it sees the new deadline.
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdeadline = t
+	c.mu.Unlock()
+	c.r.mu.Lock()
+	c.r.cond.Broadcast()
+	c.r.mu.Unlock()
+	return nil
+}
+
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdeadline = t
+	c.mu.Unlock()
+	c.w.mu.Lock()
+	c.w.cond.Broadcast()
+	c.w.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,47 @@
+package myrpc
+
+import "sync"
+
+// pendingTable holds the calls waiting for their response. It has a lock of its
+// own, so registering and removing calls doesn't contend with Close or IsAvaliable.
+type pendingTable struct {
+	mu     sync.Mutex
+	calls  map[uint64]*Call
+	closed bool
+}
+
+func newPendingTable() *pendingTable {
+	return &pendingTable{calls: make(map[uint64]*Call)}
+}
+
+// add stores call under seq, it fails once the table is closed.
+func (t *pendingTable) add(seq uint64, call *Call) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return Errshutdown
+	}
+	t.calls[seq] = call
+	return nil
+}
+
+func (t *pendingTable) remove(seq uint64) *Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	call := t.calls[seq]
+	delete(t.calls, seq)
+	return call
+}
+
+// close rejects the calls added from now on and returns those still pending.
+func (t *pendingTable) close() []*Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	calls := make([]*Call, 0, len(t.calls))
+	for seq, call := range t.calls {
+		calls = append(calls, call)
+		delete(t.calls, seq)
+	}
+	return calls
+}
@@ -0,0 +1,123 @@
+package myrpc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ReflectionService is the name of the built-in service describing what a
+// server offers, every Server created by NewServer registers it:
+//
+//	_rpc.List(prefix string) ([]string, error)             names of the services starting with prefix
+//	_rpc.Describe(name string) (*ServiceInfo, error)       methods of a service and their types
+//	_rpc.Schema(method string) (*MethodSchema, error)      JSON Schema of a method's argument and reply
+//
+// It can be removed with Unregister(ReflectionService).
+const ReflectionService = "_rpc"
+
+// ServiceInfo describes a registered service.
+type ServiceInfo struct {
+	Name    string
+	Raw     bool //raw 服务没有方法信息
+	Methods []MethodInfo
+}
+
+// MethodInfo describes a method, Reply is the type the reply pointer points to.
+type MethodInfo struct {
+	Name     string
+	Context  bool //方法接收 context.Context
+	Arg      *TypeInfo
+	Reply    *TypeInfo
+	NumCalls uint64
+}
+
+// TypeInfo describes a Go type, Fields lists the exported fields of a struct,
+// Elem is the element of a pointer, slice, array or map whose key is Key.
+// A struct type met again inside itself is described without its fields.
+type TypeInfo struct {
+	Name   string
+	Kind   string
+	Fields []FieldInfo
+	Elem   *TypeInfo
+	Key    *TypeInfo
+}
+
+type FieldInfo struct {
+	Name string
+	Type *TypeInfo
+}
+
+type reflection struct {
+	server *Server
+}
+
+func (r *reflection) List(prefix string) ([]string, error) {
+	var names []string
+	r.server.serviceMap.Range(func(namei, _ interface{}) bool {
+		if name := namei.(string); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *reflection) Describe(name string) (*ServiceInfo, error) {
+	svc, ok := r.server.lookupService(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrServiceNotFound, name)
+	}
+	return describeService(svc), nil
+}
+
+func (r *reflection) Schema(serviceMethod string) (*MethodSchema, error) {
+	return r.server.methodSchema(serviceMethod)
+}
+
+func describeService(svc *service) *ServiceInfo {
+	info := &ServiceInfo{Name: svc.name, Raw: svc.raw != nil}
+	for name, m := range svc.method {
+		info.Methods = append(info.Methods, MethodInfo{
+			Name:     name,
+			Context:  m.withCtx,
+			Arg:      describeType(m.ArgType, nil),
+			Reply:    describeType(m.ReplyType.Elem(), nil),
+			NumCalls: atomic.LoadUint64(&m.NumCalls),
+		})
+	}
+	sort.Slice(info.Methods, func(i, j int) bool {
+		return info.Methods[i].Name < info.Methods[j].Name
+	})
+	return info
+}
+
+// describeType builds the TypeInfo of t, seen holds the structs being described.
+func describeType(t reflect.Type, seen map[reflect.Type]bool) *TypeInfo {
+	info := &TypeInfo{Name: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		info.Elem = describeType(t.Elem(), seen)
+	case reflect.Map:
+		info.Key = describeType(t.Key(), seen)
+		info.Elem = describeType(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return info
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				info.Fields = append(info.Fields, FieldInfo{Name: f.Name, Type: describeType(f.Type, seen)})
+			}
+		}
+	}
+	return info
+}
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"log"
+	"myrpc"
 	"net/http"
 	"sort"
 	"strings"
@@ -71,6 +72,10 @@ func (registry *LjnResigtery) ServeHTTP(w http.ResponseWriter, req *http.Request
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if _, err := myrpc.ParseAddr(addr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		registry.putServer(addr)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
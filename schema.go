@@ -0,0 +1,483 @@
+package myrpc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The schemas describe how encoding/json encodes a type, they document the
+// JSON-RPC and gateway endpoints and validate the gateway requests.
+
+const (
+	jsonSchemaDraft   = "https://json-schema.org/draft/2020-12/schema"
+	defaultSchemaPath = "/debug/geerpc/schema"
+)
+
+// Schema is the subset of JSON Schema needed to describe Go types.
+// Nullable and Closed stand for "type": [Type, "null"] and "additionalProperties": false.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Nullable             bool               `json:"-"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Closed               bool               `json:"-"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	out := struct {
+		plain
+		Type                 interface{} `json:"type,omitempty"`
+		AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	}{plain: plain(s)}
+	if s.Type != "" && s.Nullable {
+		out.Type = []string{s.Type, "null"}
+	} else if s.Type != "" {
+		out.Type = s.Type
+	}
+	if s.Closed {
+		out.AdditionalProperties = false
+	} else if s.AdditionalProperties != nil {
+		out.AdditionalProperties = s.AdditionalProperties
+	}
+	return json.Marshal(out)
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	type plain Schema
+	in := struct {
+		*plain
+		Type                 json.RawMessage `json:"type"`
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	s.Type, s.Nullable, s.Closed, s.AdditionalProperties = "", false, false, nil
+	if len(in.Type) > 0 && in.Type[0] == '[' {
+		var types []string
+		if err := json.Unmarshal(in.Type, &types); err != nil {
+			return err
+		}
+		for _, t := range types {
+			if t == "null" {
+				s.Nullable = true
+			} else {
+				s.Type = t
+			}
+		}
+	} else if len(in.Type) > 0 {
+		if err := json.Unmarshal(in.Type, &s.Type); err != nil {
+			return err
+		}
+	}
+	switch a := bytes.TrimSpace(in.AdditionalProperties); {
+	case string(a) == "false":
+		s.Closed = true
+	case len(a) > 0 && a[0] == '{':
+		s.AdditionalProperties = new(Schema)
+		return json.Unmarshal(a, s.AdditionalProperties)
+	}
+	return nil
+}
+
+// MethodSchema holds the schemas of a method's argument and reply.
+type MethodSchema struct {
+	Arg   *Schema `json:"arg"`
+	Reply *Schema `json:"reply"`
+}
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// SchemaOf returns the JSON Schema document of t, named struct types are put
+// in $defs so that recursive types can be described.
+func SchemaOf(t reflect.Type) *Schema {
+	b := &schemaBuilder{defs: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+	s := b.schema(t)
+	root := *s
+	root.Schema = jsonSchemaDraft
+	if len(b.defs) > 0 {
+		root.Defs = b.defs
+	}
+	return &root
+}
+
+type schemaBuilder struct {
+	defs  map[string]*Schema
+	names map[reflect.Type]string //类型在 $defs 中的名字
+}
+
+// defName returns the name of t in $defs. It's t.String() unless another type
+// already has that name, e.g. a.Args and b.Args, then the package path tells
+// them apart, and a number after that.
+func (b *schemaBuilder) defName(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.String()
+	if _, taken := b.defs[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name()
+		for i := 2; ; i++ {
+			if _, taken := b.defs[name]; !taken {
+				break
+			}
+			name = fmt.Sprintf("%s.%s%d", strings.ReplaceAll(t.PkgPath(), "/", "."), t.Name(), i)
+		}
+	}
+	b.names[t] = name
+	return name
+}
+
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(iface))
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	switch {
+	case t == typeOfTime:
+		return &Schema{Type: "string", Format: "date-time"}
+	case implements(t, typeOfJSONMarshaler):
+		//自定义编码，无法推断
+		return &Schema{}
+	case implements(t, typeOfTextMarshaler):
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		min := 0.0
+		return &Schema{Type: "integer", Minimum: &min}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		return nullable(b.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64", Nullable: true}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem()), Nullable: true}
+	case reflect.Array:
+		n := t.Len()
+		return &Schema{Type: "array", Items: b.schema(t.Elem()), MinItems: &n, MaxItems: &n}
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !implements(t.Key(), typeOfTextMarshaler) {
+				return &Schema{}
+			}
+		}
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := b.defName(t)
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = nil //占位，递归引用自身时直接返回 $ref
+			b.defs[name] = b.structSchema(t)
+		}
+		return &Schema{Ref: "#/$defs/" + name}
+	}
+	return &Schema{}
+}
+
+// nullable lets s accept null as well.
+func nullable(s *Schema) *Schema {
+	switch {
+	case s.Type != "":
+		c := *s
+		c.Nullable = true
+		return &c
+	case s.Ref != "":
+		return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+	}
+	return s
+}
+
+// structSchema follows the field naming rules of encoding/json,
+// fields of embedded structs are promoted.
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema), Closed: true}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range b.embedded(ft).Properties {
+				if _, dup := s.Properties[k]; !dup {
+					s.Properties[k] = v
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := b.schema(f.Type)
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "string" && fs.Type != "" && fs.Type != "object" && fs.Type != "array" {
+				fs = &Schema{Type: "string"}
+			}
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// embedded returns the schema of an embedded struct, going through b.defs like
+// named fields do so that a struct embedding itself doesn't recurse forever.
+func (b *schemaBuilder) embedded(t reflect.Type) *Schema {
+	name := b.defName(t)
+	s, ok := b.defs[name]
+	if !ok {
+		b.defs[name] = nil
+		s = b.structSchema(t)
+		b.defs[name] = s
+	}
+	if s == nil {
+		//正在展开的外层结构体，它的字段已由外层提升
+		return &Schema{}
+	}
+	return s
+}
+
+// schemas returns the schemas of the method, built on first use.
+func (m *methodType) schemas() *MethodSchema {
+	m.schemaOnce.Do(func() {
+		m.schema = &MethodSchema{Arg: SchemaOf(m.ArgType), Reply: SchemaOf(m.ReplyType.Elem())}
+	})
+	return m.schema
+}
+
+// Validate checks a JSON document against the schema.
+func (s *Schema) Validate(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	return validateSchema(s, s, v, "$")
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// validateSchema checks v, decoded with UseNumber, against s, root holds the $defs.
+func validateSchema(root, s *Schema, v interface{}, path string) error {
+	if s.Ref != "" {
+		def := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if def == nil {
+			return fmt.Errorf("%s: unresolved reference %s", path, s.Ref)
+		}
+		s = def
+	}
+	if len(s.AnyOf) > 0 {
+		var first error
+		for _, sub := range s.AnyOf {
+			err := validateSchema(root, sub, v, path)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	if s.Type == "" || (v == nil && s.Nullable) {
+		return nil
+	}
+	mismatch := fmt.Errorf("%s: expect %s, got %s", path, s.Type, jsonKind(v))
+	switch s.Type {
+	case "null":
+		if v != nil {
+			return mismatch
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch
+		}
+	case "number", "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch
+		}
+		if s.Type == "integer" && strings.ContainsAny(string(n), ".eE") {
+			return fmt.Errorf("%s: expect integer, got %s", path, n)
+		}
+		if f, err := strconv.ParseFloat(string(n), 64); err == nil && s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s: %s is less than %v", path, n, *s.Minimum)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return mismatch
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: invalid date-time %q", path, str)
+			}
+		}
+		if s.ContentEncoding == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+				return fmt.Errorf("%s: invalid base64", path)
+			}
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		if (s.MinItems != nil && len(items) < *s.MinItems) || (s.MaxItems != nil && len(items) > *s.MaxItems) {
+			return fmt.Errorf("%s: unexpected array length %d", path, len(items))
+		}
+		for i, item := range items {
+			if s.Items == nil {
+				break
+			}
+			if err := validateSchema(root, s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub := s.property(k)
+			if sub == nil && s.Closed {
+				return fmt.Errorf("%s: unknown field %q", path, k)
+			}
+			if sub == nil {
+				continue
+			}
+			if err := validateSchema(root, sub, obj[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// property finds the schema of key, matching case-insensitively as encoding/json does.
+func (s *Schema) property(key string) *Schema {
+	if p, ok := s.Properties[key]; ok {
+		return p
+	}
+	for name, p := range s.Properties {
+		if strings.EqualFold(name, key) {
+			return p
+		}
+	}
+	return s.AdditionalProperties
+}
+
+type schemaHTTP struct {
+	*Server
+}
+
+// SchemaHandler serves the JSON Schema of every method as {"Service.Method": {"arg":..., "reply":...}},
+// or of a single one with ?method=Service.Method.
+func (server *Server) SchemaHandler() http.Handler {
+	return &schemaHTTP{server}
+}
+
+func (server *schemaHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var doc interface{}
+	if serviceMethod := req.URL.Query().Get("method"); serviceMethod != "" {
+		ms, err := server.methodSchema(serviceMethod)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		doc = ms
+	} else {
+		all := make(map[string]*MethodSchema)
+		server.serviceMap.Range(func(_, svci interface{}) bool {
+			svc := svci.(*service)
+			for name, m := range svc.method {
+				all[svc.name+"."+name] = m.schemas()
+			}
+			return true
+		})
+		doc = all
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(doc)
+}
+
+func (server *Server) methodSchema(serviceMethod string) (*MethodSchema, error) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err == nil && svc.raw != nil {
+		err = fmt.Errorf("%w %s is a raw service", ErrMethodNotFound, serviceMethod)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mtype.schemas(), nil
+}
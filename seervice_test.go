@@ -1,8 +1,12 @@
 package myrpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -62,3 +66,115 @@ func TestCall2(t *testing.T) {
 	fmt.Println(*res)
 
 }
+
+type hidden struct{}
+
+type Mixed int
+
+func (m Mixed) Good(args Args, reply *int) error         { return nil }
+func (m Mixed) NoError(args Args, reply *int)            {}
+func (m Mixed) ValueReply(args Args, reply int) error    { return nil }
+func (m Mixed) Unexported(args hidden, reply *int) error { return nil }
+func (m *Mixed) PtrOnly(args Args, reply *int) error     { return nil }
+
+func TestRegisterReport(t *testing.T) {
+	server := NewServer()
+	report, err := server.RegisterWithReport("", Mixed(0))
+	_assert(err == nil, "non strict register should succeed: %v", err)
+	_assert(reflect.DeepEqual(report.Methods, []string{"Good"}), "unexpected methods %v", report.Methods)
+	reasons := make(map[string]string)
+	for _, m := range report.Skipped {
+		reasons[m.Name] = m.Reason
+	}
+	_assert(len(reasons) == 4, "expect 4 skipped methods, got %v", report.Skipped)
+	_assert(strings.Contains(reasons["NoError"], "error"), "unexpected reason %q", reasons["NoError"])
+	_assert(strings.Contains(reasons["ValueReply"], "pointer"), "unexpected reason %q", reasons["ValueReply"])
+	_assert(strings.Contains(reasons["Unexported"], "not exported"), "unexpected reason %q", reasons["Unexported"])
+	_assert(strings.Contains(reasons["PtrOnly"], "pointer receiver"), "unexpected reason %q", reasons["PtrOnly"])
+
+	strict := NewServer()
+	strict.StrictRegister = true
+	var regErr *RegisterError
+	err = strict.RegisterName("Mixed", new(Mixed))
+	_assert(errors.As(err, &regErr) && len(regErr.Skipped) == 3, "strict register should fail: %v", err)
+	_, err = strict.RegisterWithReport("Empty", new(hidden))
+	_assert(errors.As(err, &regErr) && len(regErr.Skipped) == 0, "a service without methods should fail: %v", err)
+	var foo Foo
+	_assert(strict.Register(&foo) == nil, "strict register of Foo should succeed")
+}
+
+type Calc int
+
+func (c Calc) Mul(args Args) (int, error) {
+	return args.Num1 * args.Num2, nil
+}
+
+func (c Calc) Div(ctx context.Context, args Args) (*Args, error) {
+	if args.Num2 == 0 {
+		return nil, errors.New("divide by zero")
+	}
+	return &Args{args.Num1 / args.Num2, args.Num1 % args.Num2}, nil
+}
+
+func TestReturnReply(t *testing.T) {
+	s := NewService(new(Calc))
+	_assert(len(s.method) == 2 && len(s.skipped) == 0, "expect Mul and Div, skipped %v", s.skipped)
+	mType := s.method["Mul"]
+	argv, replyV := mType.newArgV(), mType.newReplyV()
+	argv.Set(reflect.ValueOf(Args{Num1: 3, Num2: 4}))
+	err := s.call(mType, argv, replyV)
+	_assert(err == nil && *replyV.Interface().(*int) == 12, "call Mul failed: %v", err)
+
+	server := NewServer()
+	_ = server.Register(new(Calc))
+	_ = server.HandleFunc("Math.Neg", func(n int) (int, error) { return -n, nil })
+	lis, _ := ListenMem("return-reply", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "return-reply")
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	var q Args
+	err = client.Call(context.Background(), "Calc.Div", Args{Num1: 7, Num2: 2}, &q)
+	_assert(err == nil && q == Args{3, 1}, "call Div failed: %v %v", q, err)
+	err = client.Call(context.Background(), "Calc.Div", Args{Num1: 7}, &q)
+	_assert(err != nil && err.Error() == "divide by zero", "expect divide by zero, got %v", err)
+	var n int
+	err = client.Call(context.Background(), "Math.Neg", 5, &n)
+	_assert(err == nil && n == -5, "call Math.Neg failed: %d %v", n, err)
+	_assert(server.HandleFunc("Math.Bad", func(n int) (int, int) { return 0, 0 }) != nil, "second result must be error")
+	_assert(server.HandleFunc("Math.Bad", func(n, m int) (int, error) { return 0, nil }) != nil, "returning form takes one argument")
+}
+
+func TestDebugSignature(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Calc))
+	_ = server.Register(new(Foo))
+	_ = server.HandleFunc("Math.Wait", func(ctx context.Context, n int, reply *int) error { return nil })
+	w := httptest.NewRecorder()
+	server.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/geerpc", nil))
+	page := w.Body.String()
+	for _, want := range []string{
+		"Mul(myrpc.Args) (int, error)",
+		"Div(context.Context, myrpc.Args) (*myrpc.Args, error)",
+		"Sum(myrpc.Args, *int) error",
+		"Wait(context.Context, int, *int) error",
+	} {
+		_assert(strings.Contains(page, want), "debug page should show %s: %s", want, page)
+	}
+}
+
+func BenchmarkServiceCall(b *testing.B) {
+	s := NewService(new(Calc))
+	mType := s.method["Mul"]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		argv, replyV := mType.getArgV(), mType.getReplyV()
+		argv.Set(reflect.ValueOf(Args{Num1: i, Num2: 2}))
+		if err := s.call(mType, argv, replyV); err != nil {
+			b.Fatal(err)
+		}
+		mType.putArgV(argv)
+		mType.putReplyV(replyV)
+	}
+}
@@ -0,0 +1,189 @@
+package myrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve listens on every address, given in any format ParseAddr accepts,
+// and serves them in the background until Shutdown. It returns the bound
+// addresses in the same order in the protocol@addr format, e.g. "tcp@:0" becomes
+// "tcp@[::]:41234". Address options are kept so they can be advertised to clients.
+//
+//	tcp@:9999, unix@/tmp/geerpc.sock, mem@name       raw RPC connections
+//	http@:8080, http@:8080/rpc                       RPC and debug handlers, the path sets where DialHTTP CONNECTs
+//	ws@:8080, ws@:8080/ws                            the same plus WebSocket, the path sets its endpoint
+func (server *Server) Serve(addrs ...string) ([]string, error) {
+	var closers []func()
+	bound := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		b, closer, err := server.serveAddr(addr)
+		if err != nil {
+			for _, c := range closers {
+				c()
+			}
+			return nil, err
+		}
+		closers = append(closers, closer)
+		bound = append(bound, b)
+	}
+	return bound, nil
+}
+
+func (server *Server) serveAddr(rpcAddr string) (string, func(), error) {
+	a, err := ParseAddr(rpcAddr)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := a.checkCodec(server.codecs()); err != nil {
+		return "", nil, err
+	}
+	bound := *a
+	switch a.Protocol {
+	case "http", "ws":
+		paths := DefaultHTTPPaths
+		if a.Protocol == "http" && a.Path != "" {
+			paths.RPC = a.Path
+		} else if a.Protocol == "ws" {
+			paths.WebSocket = defaultWSPath
+			if a.Path != "" {
+				paths.WebSocket = a.Path
+			}
+		}
+		lis, err := net.Listen("tcp", a.Address)
+		if err != nil {
+			return "", nil, err
+		}
+		mux := http.NewServeMux()
+		server.HandleHTTP(mux, paths)
+		hs := &http.Server{Handler: mux}
+		if !server.trackHTTPServer(hs, true) {
+			lis.Close()
+			return "", nil, errors.New("rpc server: server is shutting down")
+		}
+		go func() {
+			defer server.trackHTTPServer(hs, false)
+			_ = hs.Serve(lis)
+		}()
+		bound.Address = lis.Addr().String()
+		return bound.String(), func() { hs.Close() }, nil
+	case "mem":
+		lis, err := ListenMem(a.Address, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		go server.Accept(lis)
+		return bound.String(), func() { lis.Close() }, nil
+	default:
+		lis, err := net.Listen(a.Protocol, a.Address)
+		if err != nil {
+			return "", nil, err
+		}
+		go server.Accept(lis)
+		bound.Address = lis.Addr().String()
+		return bound.String(), func() { lis.Close() }, nil
+	}
+}
+
+// Shutdown stops accepting connections, stops reading new requests and waits
+// for the requests being handled to be answered. When ctx is done first, the
+// remaining connections are closed and ctx's error returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	server.inShutdown = true
+	for lis := range server.listeners {
+		lis.Close()
+	}
+	var httpServers []*http.Server
+	for hs := range server.httpServers {
+		httpServers = append(httpServers, hs)
+	}
+	//读超时让 serverCodec 停止读取新请求，等已读到的请求处理完再关闭连接
+	for con := range server.conns {
+		_ = con.SetReadDeadline(time.Now())
+	}
+	server.mu.Unlock()
+
+	for _, hs := range httpServers {
+		_ = hs.Shutdown(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		server.connWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.mu.Lock()
+		for con := range server.conns {
+			con.Close()
+		}
+		server.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.inShutdown
+}
+
+// trackListener adds or removes lis, adding fails once Shutdown started.
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+func (server *Server) trackHTTPServer(hs *http.Server, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.httpServers, hs)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.httpServers == nil {
+		server.httpServers = make(map[*http.Server]struct{})
+	}
+	server.httpServers[hs] = struct{}{}
+	return true
+}
+
+func (server *Server) trackConn(con net.Conn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, con)
+		server.connWG.Done()
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[net.Conn]struct{})
+	}
+	server.conns[con] = struct{}{}
+	server.connWG.Add(1)
+	return true
+}
@@ -1,6 +1,8 @@
 package myrpc
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,6 +24,12 @@ type Option struct {
 	CodeType          codec.Type
 	ConnectionTimeOut time.Duration
 	HandleTimeOut     time.Duration
+	Checksum          bool            //为每一帧附加 CRC32C 校验
+	Codecs            *codec.Registry `json:"-"` //客户端可用的编解码器，为空时使用 codec.DefaultRegistry
+	//客户端直接使用 Go 标准库 net/rpc 的 gob 协议，不发送 Option，用于连接 net/rpc 服务端
+	NetRPC bool `json:"-"`
+	//客户端到同一地址打开的连接数，调用分配给未完成请求最少的连接，小于 2 时只用一条
+	Conns int `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -32,19 +40,49 @@ var DefaultOption = &Option{
 
 type Server struct {
 	serviceMap sync.Map
+	Codecs     *codec.Registry //为空时使用 codec.DefaultRegistry
+	//为 true 时 ServerCon 同时接受 Go 标准库 net/rpc 的 gob 连接（没有 Option 握手）
+	AcceptNetRPC bool
+	//为 true 时注册的类型有导出方法被跳过或者没有可用方法，注册返回 *RegisterError
+	StrictRegister bool
+	//JSON-RPC over HTTP 和网关请求体的字节数上限，为 0 时使用 DefaultMaxBodyBytes
+	MaxBodyBytes int64
+	//决定是否接受 WebSocket 握手，为空时只接受没有 Origin 头或者 Origin 与 Host 相同的请求
+	CheckOrigin func(req *http.Request) bool
+	regMu       sync.Mutex //串行化服务的注册、注销和替换
+	//服务名 -> 默认版本的完整名称（如 Foo@v2），没有指定版本的请求使用它
+	defaults        sync.Map
+	defaultVersions map[string]string //SetDefaultVersion 指定的默认版本
+
+	//以下字段用于优雅关闭
+	mu          sync.Mutex
+	listeners   map[net.Listener]struct{}
+	httpServers map[*http.Server]struct{}
+	conns       map[net.Conn]struct{}
+	connWG      sync.WaitGroup
+	inShutdown  bool
 }
 
 func NewServer() *Server {
-	return &Server{}
+	server := &Server{}
+	_ = server.RegisterName(ReflectionService, &reflection{server})
+	return server
 }
 
 var DefaultServer = NewServer()
 
 func (this *Server) Accept(lis net.Listener) {
+	if !this.trackListener(lis, true) {
+		lis.Close()
+		return
+	}
+	defer this.trackListener(lis, false)
 	for {
 		con, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !this.shuttingDown() {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 		go this.ServerCon(con)
@@ -57,10 +95,28 @@ func Accept(lis net.Listener) {
 
 func (this *Server) ServerCon(con net.Conn) {
 	defer func() { con.Close() }()
+	if !this.trackConn(con, true) {
+		return
+	}
+	defer this.trackConn(con, false)
 
+	if this.AcceptNetRPC {
+		//Option 是 JSON 对象，以 '{' 开头，net/rpc 的连接以 gob 消息长度开头
+		br := bufio.NewReader(con)
+		b, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		con = &bufferedConn{Conn: con, r: br}
+		if b[0] != '{' {
+			this.ServeNetRPC(con)
+			return
+		}
+	}
 	//获取配置项
 	var option Option
-	if err := json.NewDecoder(con).Decode(&option); err != nil {
+	dec := json.NewDecoder(con)
+	if err := dec.Decode(&option); err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
@@ -70,72 +126,169 @@ func (this *Server) ServerCon(con net.Conn) {
 		log.Println("rpc server: magincNumber error: ")
 		return
 	}
-	f := codec.NewCodecFuncMap[option.CodeType]
-	if f == nil {
+	f, ok := this.codecs().Lookup(option.CodeType)
+	if !ok {
 		log.Printf("rpc server: invalid codec type %s", option.CodeType)
 		return
 	}
-	cc := f(con)
+	//json 解码器可能多读了紧跟在 Option 之后的数据，Encoder 写入的换行符也要跳过
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), con))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	var conn io.ReadWriteCloser = &bufferedConn{Conn: con, r: r}
+	if option.Checksum {
+		conn = codec.NewChecksumConn(conn)
+	}
+	cc := f(conn)
 	this.serverCodec(cc, &option)
 }
 
+// ServeNetRPC serves a connection speaking the protocol of Go's standard net/rpc,
+// like rpc.ServeConn. Batch calls are not available on such connections.
+func (this *Server) ServeNetRPC(conn io.ReadWriteCloser) {
+	this.serverCodec(codec.NewNetRPCCodec(conn), &Option{MagicNumber: MagicNumber, CodeType: codec.GobType})
+}
+
+// bufferedConn replays the bytes the handshake decoder read ahead of the option.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (server *Server) codecs() *codec.Registry {
+	if server.Codecs != nil {
+		return server.Codecs
+	}
+	return codec.DefaultRegistry
+}
+
 var invalidRequest = struct{}{}
 
 func (this *Server) serverCodec(cc codec.Codec, opt *Option) {
 	wg := new(sync.WaitGroup)
-	sending := new(sync.Mutex)
+	w := newConnWriter(cc)
 	for {
-		req, err := this.readRequest(cc)
+		req, err := this.readRequest(cc, opt.CodeType)
 		if err != nil {
 			if req == nil {
+				if errors.Is(err, codec.ErrChecksum) {
+					//请求头已损坏，不知道是哪个请求：用 Seq 0 告诉客户端整条连接失效
+					this.sendResponse(w, &codec.Header{Error: err.Error()}, invalidRequest)
+				}
 				break //it's not possible to recover, so close the connection
-			} else {
-				req.h.Error = err.Error()
-				this.sendResponse(cc, req.h, invalidRequest, sending)
 			}
+			req.h.Error = err.Error()
+			this.sendResponse(w, req.h, invalidRequest)
+			if errors.Is(err, codec.ErrChecksum) {
+				break
+			}
+		} else if req.batch != nil {
+			wg.Add(1)
+			go this.handleBatch(req, wg, w, opt.HandleTimeOut)
 		} else {
-			go this.handleReq(req, wg, cc, sending, opt.HandleTimeOut)
 			wg.Add(1)
+			go this.handleReq(req, wg, w, opt.HandleTimeOut)
 		}
 	}
 	wg.Wait()
+	w.Close()
 	cc.Close()
 }
 
-func (server *Server) handleReq(req *request, wg *sync.WaitGroup, cc codec.Codec, mtx *sync.Mutex, timeOut time.Duration) {
+func (server *Server) handleReq(req *request, wg *sync.WaitGroup, w *connWriter, timeOut time.Duration) {
 	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
-	go func() {
-		//log.Println("服务器处理请求 ", "消息header: ", req.h, "消息arg： ", req.argv.Elem())
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, mtx)
-			return
-		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), mtx)
-		sent <- struct{}{}
-	}()
-	if timeOut == 0 {
-		<-called
-		<-sent
+	reply, err := server.invoke(req, timeOut)
+	if err != nil {
+		req.h.Error = err.Error()
+		server.sendResponse(w, req.h, invalidRequest)
+		req.release()
 		return
 	}
+	server.sendResponse(w, req.h, reply)
+	req.release()
+}
 
+// invoke calls the method of req and returns its reply, giving up after timeOut if it's not zero.
+func (server *Server) invoke(req *request, timeOut time.Duration) (interface{}, error) {
+	if timeOut == 0 {
+		//没有超时限制时直接在当前协程调用
+		return server.call(context.Background(), req)
+	}
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	//带缓冲，超时后处理协程也能正常退出
+	called := make(chan result, 1)
+	//超时后取消 ctx，接收 context.Context 的方法可以提前返回
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	go func() {
+		reply, err := server.call(ctx, req)
+		called <- result{reply, err}
+	}()
+	timer := time.NewTimer(timeOut)
+	defer timer.Stop()
 	select {
-	case <-time.After(timeOut):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeOut)
-		server.sendResponse(cc, req.h, invalidRequest, mtx)
-	case <-called:
-		<-sent
+	case <-timer.C:
+		//方法可能仍在使用 argv 和 replyv，不能放回池中
+		req.timedOut = true
+		return nil, fmt.Errorf("rpc server: request handle timeout: expect within %s", timeOut)
+	case res := <-called:
+		return res.reply, res.err
+	}
+}
+
+func (server *Server) call(ctx context.Context, req *request) (interface{}, error) {
+	//log.Println("服务器处理请求 ", "消息header: ", req.h, "消息arg： ", req.argv.Elem())
+	for {
+		var reply interface{}
+		var err error
+		if req.svc.raw != nil {
+			reply, err = req.svc.serveRaw(&RawRequest{ServiceMethod: req.h.ServeiceMethod, CodecType: req.codecType, Body: req.raw})
+		} else {
+			err = req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+			reply = req.replyv.Interface()
+		}
+		svc, mtype, ok := server.redispatch(err, req.svc, req.mtype, req.h.ServeiceMethod)
+		if !ok {
+			return reply, err
+		}
+		req.svc, req.mtype = svc, mtype
+	}
+}
+
+// redispatch finds the service that replaced svc when err tells that svc was
+// drained, so that a request read before Replace and invoked after it reaches the
+// new service. The argument is already decoded: the new method must take and
+// return the same types.
+func (server *Server) redispatch(err error, svc *service, mtype *methodType, serviceMethod string) (*service, *methodType, bool) {
+	var drained *drainedError
+	if !errors.As(err, &drained) {
+		return nil, nil, false
+	}
+	svci, ok := server.serviceMap.Load(svc.name)
+	if !ok || svci.(*service) == svc {
+		return nil, nil, false
+	}
+	s := svci.(*service)
+	if svc.raw != nil || s.raw != nil {
+		return s, nil, svc.raw != nil && s.raw != nil
+	}
+	m, ok := s.method[serviceMethod[strings.LastIndex(serviceMethod, ".")+1:]]
+	if !ok || m.ArgType != mtype.ArgType || m.ReplyType != mtype.ReplyType {
+		return nil, nil, false
 	}
+	return s, m, true
 }
-func (*Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sendingMtx *sync.Mutex) {
-	sendingMtx.Lock()
-	defer sendingMtx.Unlock()
-	if err := cc.Write(h, body); err != nil {
+
+func (*Server) sendResponse(w *connWriter, h *codec.Header, body interface{}) {
+	if err := w.Write(h, body); err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
@@ -145,18 +298,55 @@ type request struct {
 	argv, replyv reflect.Value
 	mtype        *methodType
 	svc          *service
+	codecType    codec.Type
+	raw          codec.RawMessage //raw 服务的请求体，不解码
+	batch        []*request       //批量请求中的各个请求
+	batchOpt     BatchOption
+	err          error //批量请求中单个请求读取时的错误
+	timedOut     bool  //处理超时，方法可能仍在运行
+}
+
+// release gives argv and replyv back to the pools of the method once the
+// response is written.
+func (req *request) release() {
+	if req.mtype == nil || req.timedOut {
+		return
+	}
+	req.mtype.putArgV(req.argv)
+	req.mtype.putReplyV(req.replyv)
+	req.argv, req.replyv = reflect.Value{}, reflect.Value{}
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
+func (server *Server) readRequest(cc codec.Codec, codecType codec.Type) (*request, error) {
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
-	req.svc, req.mtype, err = server.findService(h.ServeiceMethod)
+	if h.Batch > 0 {
+		return server.readBatch(cc, h, codecType)
+	}
+	req := &request{h: h, codecType: codecType}
+	req.svc, req.mtype, err = server.findService(withVersion(h.ServeiceMethod, h.Version))
+	if err == nil && req.svc.raw != nil {
+		if rc, ok := cc.(codec.RawCodec); ok {
+			req.raw, err = rc.ReadRawBody()
+			if err != nil {
+				return nil, err
+			}
+			return req, nil
+		}
+		err = fmt.Errorf("rpc server: codec %s does not support raw service %s", codecType, req.svc.name)
+	}
+	if err != nil {
+		//跳过请求体，保证后续请求能正常读取
+		if bodyErr := cc.ReadBody(nil); errors.Is(bodyErr, codec.ErrChecksum) {
+			return nil, bodyErr
+		}
+		return req, err
+	}
 
-	req.argv = req.mtype.newArgV()
-	req.replyv = req.mtype.newReplyV()
+	req.argv = req.mtype.getArgV()
+	req.replyv = req.mtype.getReplyV()
 	// make sure that argvi is a pointer, ReadBody need a pointer as parameter
 	argvi := req.argv.Interface()
 	if req.argv.Type().Kind() != reflect.Ptr {
@@ -164,6 +354,8 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	}
 	if err = cc.ReadBody(argvi); err != nil {
 		log.Println("rpc server: read argv err:", err)
+		//参数无法解码时回复错误，不用零值参数调用方法；校验失败时回复后还会断开连接
+		return req, err
 	}
 	return req, nil
 }
@@ -179,30 +371,168 @@ func (*Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	return &h, nil
 }
 
+// Register publishes the methods of rcvr as a service named after its type.
 func (server *Server) Register(rcvr interface{}) error {
-	s := NewService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+// RegisterName is like Register but uses name for the service.
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	_, err := server.RegisterWithReport(name, rcvr)
+	return err
+}
+
+// RegisterWithReport is like RegisterName and also reports which methods were
+// published and which exported ones were skipped, with the reason.
+func (server *Server) RegisterWithReport(name string, rcvr interface{}) (*RegisterReport, error) {
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return nil, err
+	}
+	report := s.report()
+	if err = server.checkStrict(report); err != nil {
+		return report, err
+	}
+	return report, server.store(s)
+}
+
+func (server *Server) checkStrict(report *RegisterReport) error {
+	if !server.StrictRegister {
+		return nil
+	}
+	return report.Err()
+}
+
+// Unregister removes a service, requests read afterwards fail with ErrServiceNotFound.
+// It returns once the calls already running on the service have returned.
+func (server *Server) Unregister(name string) error {
+	server.regMu.Lock()
+	svci, ok := server.serviceMap.LoadAndDelete(name)
+	if ok {
+		server.updateDefault(name)
+	}
+	server.regMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w %s", ErrServiceNotFound, name)
+	}
+	svci.(*service).drain()
+	return nil
+}
+
+// Replace registers rcvr under name (its type name when empty) in place of the
+// current service, if any. Requests read afterwards are served by rcvr, Replace
+// returns once the calls running on the old service have returned.
+func (server *Server) Replace(name string, rcvr interface{}) error {
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return err
+	}
+	if err = server.checkStrict(s.report()); err != nil {
+		return err
+	}
+	server.regMu.Lock()
+	old, loaded := server.serviceMap.Load(s.name)
+	server.serviceMap.Store(s.name, s)
+	server.updateDefault(s.name)
+	server.regMu.Unlock()
+	if loaded {
+		old.(*service).drain()
+	}
+	return nil
+}
+
+// HandleFunc registers fn as the method serviceMethod ("Service.Method"). fn has
+// the signature of a method without its receiver: func(args T, reply *R) error or
+// func(args T) (R, error), optionally taking a context.Context first. The functions
+// registered under the same service name make up one service.
+func (server *Server) HandleFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 || dot == len(serviceMethod)-1 {
+		return fmt.Errorf("%w %s", ErrIllFormed, serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if err := checkServiceName(serviceName); err != nil {
+		return err
+	}
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return errors.New("rpc: HandleFunc needs a function for " + serviceMethod)
+	}
+	mType, err := newMethodType(f.Type(), 0)
+	if err != nil {
+		return fmt.Errorf("rpc: function for %s %s", serviceMethod, err)
+	}
+	mType.fn = f
+
+	server.regMu.Lock()
+	defer server.regMu.Unlock()
+	//复制一份再替换，正在读取 method 的请求不受影响
+	s := &service{name: serviceName, method: make(map[string]*methodType), calls: new(callTracker)}
+	if svci, ok := server.serviceMap.Load(serviceName); ok {
+		old := svci.(*service)
+		if old.raw != nil || old.rcvr.IsValid() {
+			return errors.New("rpc: service already defined: " + serviceName)
+		}
+		if old.method[methodName] != nil {
+			return errors.New("rpc: method already defined: " + serviceMethod)
+		}
+		for name, m := range old.method {
+			s.method[name] = m
+		}
+		s.calls = old.calls
+	}
+	s.method[methodName] = mType
+	server.serviceMap.Store(serviceName, s)
+	server.updateDefault(serviceName)
+	log.Printf("rpc server: register %s\n", serviceMethod)
+	return nil
+}
+
+// store adds s unless a service with the same name exists.
+func (server *Server) store(s *service) error {
+	server.regMu.Lock()
+	defer server.regMu.Unlock()
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
+	server.updateDefault(s.name)
+	return nil
+}
+
+// checkServiceName accepts "Name" and "Name@version", only the version may contain dots.
+func checkServiceName(name string) error {
+	base, version := splitVersion(name)
+	if base == "" || strings.Contains(base, ".") || strings.ContainsAny(name, "/?&=#, ") ||
+		(strings.Contains(name, "@") && (version == "" || strings.Contains(version, "@"))) {
+		return errors.New("rpc: invalid service name: " + name)
+	}
 	return nil
 }
 
+var (
+	ErrIllFormed       = errors.New("rpc server: service/method request ill-formed:")
+	ErrServiceNotFound = errors.New("rpc server: can't find service")
+	ErrMethodNotFound  = errors.New("rpc server: can't find method")
+)
+
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot == -1 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = fmt.Errorf("%w %s", ErrIllFormed, serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
-	svci, ok := server.serviceMap.Load(serviceName)
+	svc, ok := server.lookupService(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = fmt.Errorf("%w %s", ErrServiceNotFound, serviceName)
+		return
+	}
+	if svc.raw != nil {
 		return
 	}
-	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = fmt.Errorf("%w %s", ErrMethodNotFound, methodName)
 	}
 	return
 }
@@ -211,10 +541,60 @@ func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
+func HandleFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.HandleFunc(serviceMethod, fn)
+}
+
+func Unregister(name string) error {
+	return DefaultServer.Unregister(name)
+}
+
+func Replace(name string, rcvr interface{}) error {
+	return DefaultServer.Replace(name, rcvr)
+}
+
+// RawRequest is a request for a raw service, Body is still encoded with CodecType.
+type RawRequest struct {
+	ServiceMethod string
+	CodecType     codec.Type
+	Body          codec.RawMessage
+}
+
+// RawHandler serves every method of a raw service without decoding the request
+// or encoding the reply, which must already be encoded with req.CodecType.
+// It's meant for proxies and gateways, and requires a codec implementing codec.RawCodec.
+type RawHandler interface {
+	ServeRaw(req *RawRequest) (codec.RawMessage, error)
+}
+
+type RawHandlerFunc func(req *RawRequest) (codec.RawMessage, error)
+
+func (f RawHandlerFunc) ServeRaw(req *RawRequest) (codec.RawMessage, error) {
+	return f(req)
+}
+
+// RegisterRaw routes every "name.Method" call to h.
+func (server *Server) RegisterRaw(name string, h RawHandler) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	return server.store(&service{name: name, raw: h, calls: new(callTracker)})
+}
+
+func RegisterRaw(name string, h RawHandler) error {
+	return DefaultServer.RegisterRaw(name, h)
+}
+
 const (
 	connected        = "200 Connected to Gee RPC"
 	defaultRPCPath   = "/_geeprc_"
 	defaultDebugPath = "/debug/geerpc"
+	defaultJSONPath  = "/_geerpc_/jsonrpc"
+	defaultGatePath  = "/_geerpc_/gateway/"
 )
 
 func (server *Server) ServeHTTP(response http.ResponseWriter, req *http.Request) {
@@ -234,11 +614,55 @@ func (server *Server) ServeHTTP(response http.ResponseWriter, req *http.Request)
 	server.ServerCon(con)
 }
 
-func (server *Server) handleHttp() {
-	http.Handle(defaultRPCPath, server)
-	http.Handle(defaultDebugPath, &debugHTTP{server})
+// HTTPPaths tells HandleHTTP where to mount each handler, an empty path skips it.
+type HTTPPaths struct {
+	RPC       string //CONNECT 接入，对应 DialHTTP
+	Debug     string
+	JSONRPC   string
+	Gateway   string //以 / 结尾，匹配其下所有 /{Service}/{Method}
+	WebSocket string
+	Schema    string //各方法参数和返回值的 JSON Schema
+}
+
+// DefaultHTTPPaths mounts the RPC and debug handlers only, the other handlers
+// accept requests from any HTTP client and must be asked for, e.g. with AllHTTPPaths.
+var DefaultHTTPPaths = HTTPPaths{
+	RPC:   defaultRPCPath,
+	Debug: defaultDebugPath,
+}
+
+// AllHTTPPaths mounts every handler at its default path.
+var AllHTTPPaths = HTTPPaths{
+	RPC:       defaultRPCPath,
+	Debug:     defaultDebugPath,
+	JSONRPC:   defaultJSONPath,
+	Gateway:   defaultGatePath,
+	WebSocket: defaultWSPath,
+	Schema:    defaultSchemaPath,
+}
+
+// HandleHTTP mounts the handlers of server on mux, so several servers can
+// share one mux under different paths.
+func (server *Server) HandleHTTP(mux *http.ServeMux, paths HTTPPaths) {
+	handlers := []struct {
+		path    string
+		handler http.Handler
+	}{
+		{paths.RPC, server},
+		{paths.Debug, server.DebugHandler()},
+		{paths.JSONRPC, server.JSONRPCHandler()},
+		{paths.Gateway, server.GatewayHandler()},
+		{paths.WebSocket, server.WebSocketHandler()},
+		{paths.Schema, server.SchemaHandler()},
+	}
+	for _, h := range handlers {
+		if h.path != "" {
+			mux.Handle(h.path, h.handler)
+		}
+	}
 }
 
+// HandleHttp mounts the RPC and debug handlers of DefaultServer on http.DefaultServeMux.
 func HandleHttp() {
-	DefaultServer.handleHttp()
+	DefaultServer.HandleHTTP(http.DefaultServeMux, DefaultHTTPPaths)
 }
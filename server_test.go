@@ -0,0 +1,625 @@
+package myrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myrpc/codec"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFooServer() *Server {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	return server
+}
+
+func TestJSONRPCHTTP(t *testing.T) {
+	ts := httptest.NewServer(newFooServer().JSONRPCHandler())
+	defer ts.Close()
+	post := func(body string) (int, string) {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+		_assert(err == nil, "post failed: %v", err)
+		defer resp.Body.Close()
+		var sb strings.Builder
+		_, _ = bufio.NewReader(resp.Body).WriteTo(&sb)
+		return resp.StatusCode, sb.String()
+	}
+
+	_, body := post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`)
+	_assert(body == `{"jsonrpc":"2.0","result":3,"id":1}`, "unexpected response %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":"Foo.Swap","params":[{"Num1":1,"Num2":2}],"id":"a"}`)
+	_assert(body == `{"jsonrpc":"2.0","result":{"Num1":2,"Num2":1},"id":"a"}`, "unexpected response %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":"Foo.Nope","id":2}`)
+	_assert(strings.Contains(body, `"code":-32601`), "expect method not found, got %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":"x","id":3}`)
+	_assert(strings.Contains(body, `"code":-32602`), "expect invalid params, got %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":`)
+	_assert(strings.Contains(body, `"code":-32700`) && strings.Contains(body, `"id":null`), "expect parse error, got %s", body)
+
+	_, body = post(`[{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1},"id":1},{"jsonrpc":"2.0","method":"Foo.Sum"},1]`)
+	var resps []jsonrpcResponse
+	_ = json.Unmarshal([]byte(body), &resps)
+	_assert(len(resps) == 2 && string(resps[0].Result) == "1" && resps[1].Error.Code == jsonrpcInvalidRequest, "unexpected batch response %s", body)
+
+	code, _ := post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}`)
+	_assert(code == http.StatusNoContent, "notification should get no content, got %d", code)
+	code, _ = post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":"` + strings.Repeat("x", DefaultMaxBodyBytes) + `","id":1}`)
+	_assert(code == http.StatusRequestEntityTooLarge, "expect 413, got %d", code)
+}
+
+func TestJSONRPCSliceParams(t *testing.T) {
+	server := newFooServer()
+	_ = server.HandleFunc("Slice.Total", func(ns []int) (int, error) {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		return total, nil
+	})
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	defer ts.Close()
+	for params, want := range map[string]string{"[5]": "5", "[1,2,3]": "6", "[[1,2]]": "3", "[]": "0"} {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"Slice.Total","params":`+params+`,"id":1}`))
+		_assert(err == nil, "post failed: %v", err)
+		var r jsonrpcResponse
+		_ = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		_assert(string(r.Result) == want, "params %s: expect %s, got %s %v", params, want, r.Result, r.Error)
+	}
+}
+
+func TestJSONRPCConn(t *testing.T) {
+	server := newFooServer()
+	c1, c2 := net.Pipe()
+	go server.ServeJSONRPC(c1)
+	defer c2.Close()
+	_, _ = c2.Write([]byte(`{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":4,"Num2":5}],"id":7}` + "\n"))
+	line, err := bufio.NewReader(c2).ReadString('\n')
+	_assert(err == nil && line == `{"jsonrpc":"2.0","result":9,"id":7}`+"\n", "unexpected response %q %v", line, err)
+}
+
+func TestJSONRPCShutdown(t *testing.T) {
+	var slow Slow
+	server := NewServer()
+	_ = server.Register(&slow)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.AcceptJSONRPC(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial failed: %v", err)
+	defer conn.Close()
+	_, _ = conn.Write([]byte(`{"jsonrpc":"2.0","method":"Slow.Sleep","params":[100],"id":1}` + "\n"))
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown should wait for the call in flight, returned %v", err)
+	default:
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	_assert(err == nil && line == `{"jsonrpc":"2.0","result":100,"id":1}`+"\n", "unexpected response %q %v", line, err)
+	_assert(<-done == nil, "shutdown failed")
+	_, err = net.Dial("tcp", l.Addr().String())
+	_assert(err != nil, "the listener should be closed by shutdown")
+}
+
+func TestGateway(t *testing.T) {
+	server := newFooServer()
+	server.MaxBodyBytes = 64
+	ts := httptest.NewServer(http.StripPrefix("/rpc", server.GatewayHandler()))
+	defer ts.Close()
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		_assert(err == nil, "post failed: %v", err)
+		defer resp.Body.Close()
+		var sb strings.Builder
+		_, _ = bufio.NewReader(resp.Body).WriteTo(&sb)
+		return resp.StatusCode, strings.TrimSpace(sb.String())
+	}
+
+	code, body := post("/rpc/Foo/Swap", `{"Num1":1,"Num2":2}`)
+	_assert(code == http.StatusOK && body == `{"Num1":2,"Num2":1}`, "unexpected response %d %s", code, body)
+	code, body = post("/rpc/Foo/Sum", ``)
+	_assert(code == http.StatusOK && body == `0`, "unexpected response %d %s", code, body)
+	code, body = post("/rpc/Foo/Nope", `{}`)
+	_assert(code == http.StatusNotFound && strings.Contains(body, `"code":-32601`), "unexpected response %d %s", code, body)
+	code, _ = post("/rpc/Bar/Sum", `{}`)
+	_assert(code == http.StatusNotFound, "expect 404 for unknown service, got %d", code)
+	code, body = post("/rpc/Foo/Sum", `{"Num3":1}`)
+	_assert(code == http.StatusBadRequest && strings.Contains(body, `"code":-32602`), "unexpected response %d %s", code, body)
+
+	code, _ = post("/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`+strings.Repeat(" ", 64))
+	_assert(code == http.StatusRequestEntityTooLarge, "expect 413, got %d", code)
+
+	resp, _ := http.Get(ts.URL + "/rpc/Foo/Sum")
+	resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusMethodNotAllowed, "expect 405, got %d", resp.StatusCode)
+}
+
+type unexported int
+
+func (unexported) Sum(args Args, reply *int) error { return nil }
+
+func TestRegisterName(t *testing.T) {
+	var foo Foo
+	var baz Baz
+	var slow Slow
+	server := NewServer()
+	_assert(server.RegisterName("Calc", &foo) == nil, "register Calc failed")
+	_assert(server.RegisterName("Calc", &baz) != nil, "duplicate name should fail")
+	_assert(server.RegisterName("a.b", &foo) != nil, "name with a dot should fail")
+	_assert(server.Register(new(unexported)) != nil, "unexported type name should fail")
+	_assert(server.RegisterName("Hidden", new(unexported)) == nil, "explicit name should be accepted")
+	_assert(server.Register(nil) != nil, "nil receiver should fail")
+	_ = server.Register(&slow)
+	lis, _ := ListenMem("register-name", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "register-name")
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	sum := func() (int, error) {
+		var reply int
+		err := client.Call(context.Background(), "Calc.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		return reply, err
+	}
+	reply, err := sum()
+	_assert(err == nil && reply == 3, "call Calc.Sum failed: %d %v", reply, err)
+
+	//替换后新请求由新的实现处理
+	_assert(server.Replace("Calc", &baz) == nil, "replace failed")
+	reply, err = sum()
+	_assert(err == nil && reply == 103, "expect the replaced service, got %d %v", reply, err)
+
+	//注销要等正在执行的调用返回
+	call := client.Go("Slow.Sleep", 200, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_assert(server.Unregister("Slow") == nil, "unregister failed")
+	_assert(time.Since(start) > 100*time.Millisecond, "unregister should wait for the running call")
+	<-call.Done
+	_assert(call.Error == nil, "running call should succeed: %v", call.Error)
+	err = client.Call(context.Background(), "Slow.Sleep", 1, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect service not found, got %v", err)
+	_assert(errors.Is(server.Unregister("Slow"), ErrServiceNotFound), "second unregister should fail")
+}
+
+func TestReplaceRace(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+
+	//在 Replace 之前读出、之后才调用的请求交给新的服务
+	svc, mtype, err := server.findService("Foo.Sum")
+	_assert(err == nil, "find failed: %v", err)
+	_ = server.Replace("Foo", new(Foo))
+	req := &request{h: &codec.Header{ServeiceMethod: "Foo.Sum"}, svc: svc, mtype: mtype, argv: mtype.newArgV(), replyv: mtype.newReplyV()}
+	req.argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 2}))
+	reply, err := server.call(context.Background(), req)
+	_assert(err == nil && *reply.(*int) == 3, "request read before Replace failed: %v", err)
+	_ = server.Replace("Foo", new(Baz))
+	req.svc = svc
+	_, err = server.call(context.Background(), req)
+	_assert(err == nil, "a method with the same types should take the request: %v", err)
+	_ = server.Replace("Foo", new(Slow))
+	_, err = server.call(context.Background(), req)
+	_assert(errors.Is(err, ErrServiceNotFound), "a replacement without the method can't take the request, got %v", err)
+
+	//调用与 Replace 并发时都应成功
+	_ = server.Replace("Foo", &foo)
+	lis, _ := ListenMem("replace-race", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "replace-race")
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_ = server.Replace("Foo", new(Foo))
+		}
+	}()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var sum int
+				err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+				_assert(err == nil && sum == 3, "call racing Replace failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHandleFunc(t *testing.T) {
+	server := newFooServer()
+	add := func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	}
+	hasDeadline := func(ctx context.Context, args int, reply *bool) error {
+		_, *reply = ctx.Deadline()
+		return nil
+	}
+	_assert(server.HandleFunc("Math.Add", add) == nil, "register Math.Add failed")
+	_assert(server.HandleFunc("Math.HasDeadline", hasDeadline) == nil, "register Math.HasDeadline failed")
+	_assert(server.HandleFunc("Math.Add", add) != nil, "duplicate method should fail")
+	_assert(server.HandleFunc("Foo.Add", add) != nil, "adding to a type service should fail")
+	_assert(errors.Is(server.HandleFunc("Add", add), ErrIllFormed), "missing service name should fail")
+	_assert(server.HandleFunc("Math.Nil", nil) != nil, "nil function should fail")
+	_assert(server.HandleFunc("Math.Bad", func(args Args) error { return nil }) != nil, "missing reply should fail")
+	_assert(server.HandleFunc("Math.Bad", func(args Args, reply int) error { return nil }) != nil, "non pointer reply should fail")
+	_assert(server.HandleFunc("Math.Bad", func(args Args, reply *int) {}) != nil, "missing error result should fail")
+
+	lis, _ := ListenMem("handle-func", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "handle-func", &Option{HandleTimeOut: time.Second})
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	var sum int
+	err = client.Call(context.Background(), "Math.Add", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "call Math.Add failed: %d %v", sum, err)
+	var ok bool
+	err = client.Call(context.Background(), "Math.HasDeadline", 0, &ok)
+	_assert(err == nil && ok, "handle timeout should set a deadline on ctx: %v", err)
+
+	ts := httptest.NewServer(server.GatewayHandler())
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/Math/Add", "application/json", strings.NewReader(`{"Num1":3,"Num2":4}`))
+	_assert(err == nil, "post failed: %v", err)
+	defer resp.Body.Close()
+	_ = json.NewDecoder(resp.Body).Decode(&sum)
+	_assert(resp.StatusCode == http.StatusOK && sum == 7, "gateway call failed: %d %d", resp.StatusCode, sum)
+
+	_assert(server.Unregister("Math") == nil, "unregister Math failed")
+	err = client.Call(context.Background(), "Math.Add", Args{}, &sum)
+	_assert(err != nil, "Math.Add should be gone")
+}
+
+type treeNode struct {
+	Value    int
+	Children []*treeNode
+}
+
+func TestReflection(t *testing.T) {
+	server := newFooServer()
+	lis, _ := ListenMem("reflection", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	for _, ct := range []codec.Type{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("mem", "reflection", &Option{CodeType: ct})
+		_assert(err == nil, "dial failed: %v", err)
+		var names []string
+		err = client.Call(context.Background(), "_rpc.List", "", &names)
+		_assert(err == nil && strings.Join(names, ",") == "Foo,_rpc", "unexpected services %v %v", names, err)
+		var info ServiceInfo
+		err = client.Call(context.Background(), "_rpc.Describe", "Foo", &info)
+		_assert(err == nil && len(info.Methods) == 2, "describe Foo failed: %+v %v", info, err)
+		sum := info.Methods[0]
+		_assert(sum.Name == "Sum" && sum.Arg.Name == "myrpc.Args" && sum.Reply.Kind == "int", "unexpected method %+v", sum)
+		_assert(len(sum.Arg.Fields) == 2 && sum.Arg.Fields[1].Name == "Num2" && sum.Arg.Fields[1].Type.Name == "int",
+			"unexpected fields %+v", sum.Arg.Fields)
+		err = client.Call(context.Background(), "_rpc.Describe", "Nope", &info)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect service not found, got %v", err)
+		client.Close()
+	}
+
+	node := describeType(reflect.TypeOf(treeNode{}), nil)
+	inner := node.Fields[1].Type.Elem.Elem
+	_assert(inner.Name == "myrpc.treeNode" && inner.Fields == nil, "recursive type should stop, got %+v", inner)
+	_assert(server.Unregister(ReflectionService) == nil, "reflection service should be removable")
+}
+
+type schemaArgs struct {
+	Name    string           `json:"name"`
+	Tags    []string         `json:"tags,omitempty"`
+	Limits  map[string]uint  `json:"limits"`
+	At      time.Time        `json:"at"`
+	Count   int64            `json:"count,string"`
+	Skipped string           `json:"-"`
+	Child   *schemaArgs      `json:"child"`
+	Extra   map[string]*Args `json:"extra"`
+	Raw     []byte           `json:"raw"`
+	Pair    [2]int           `json:"pair"`
+	hidden  int
+}
+
+type schemaSelf struct {
+	*schemaSelf
+	Name string `json:"name"`
+}
+
+type schemaOuter struct {
+	*schemaInner
+	Outer int `json:"outer"`
+}
+
+type schemaInner struct {
+	*schemaOuter
+	Inner int `json:"inner"`
+}
+
+func TestSchemaEmbeddedCycle(t *testing.T) {
+	s := SchemaOf(reflect.TypeOf(schemaSelf{}))
+	self := s.Defs["myrpc.schemaSelf"]
+	_assert(self != nil && len(self.Properties) == 1 && self.Properties["name"].Type == "string", "unexpected schema %+v", self)
+	s = SchemaOf(reflect.TypeOf(schemaOuter{}))
+	outer := s.Defs["myrpc.schemaOuter"]
+	_assert(outer != nil && len(outer.Properties) == 2 && outer.Properties["inner"] != nil && outer.Properties["outer"] != nil,
+		"unexpected schema %+v", outer)
+	_assert(s.Validate([]byte(`{"outer":1,"inner":2}`)) == nil, "valid document rejected")
+}
+
+func TestSchemaSameNames(t *testing.T) {
+	//与包级的 Args 同名的另一个类型
+	type Args struct {
+		Text string
+	}
+	type pair struct {
+		A Args
+		B struct{ Num Args }
+		C *packageArgs
+	}
+	s := SchemaOf(reflect.TypeOf(pair{}))
+	root := s.Defs["myrpc.pair"]
+	_assert(root != nil, "unexpected defs %v", s.Defs)
+	a, c := root.Properties["A"].Ref, root.Properties["C"].AnyOf[0].Ref
+	_assert(a != c && a == root.Properties["B"].Properties["Num"].Ref, "same named types should get different defs: %s %s", a, c)
+	for ref, field := range map[string]string{a: "Text", c: "Num1"} {
+		def := s.Defs[strings.TrimPrefix(ref, "#/$defs/")]
+		_assert(def != nil && def.Properties[field] != nil, "%s should describe %s: %+v", ref, field, def)
+	}
+	_assert(s.Validate([]byte(`{"A":{"Text":"x"},"B":{"Num":{"Text":"y"}},"C":{"Num1":1,"Num2":2}}`)) == nil, "valid document rejected")
+	_assert(s.Validate([]byte(`{"C":{"Text":"x"}}`)) != nil, "C should be validated as the package level Args")
+}
+
+// packageArgs names the package level Args where a local type shadows it.
+type packageArgs = Args
+
+func TestSchema(t *testing.T) {
+	s := SchemaOf(reflect.TypeOf(schemaArgs{}))
+	data, err := json.Marshal(s)
+	_assert(err == nil, "marshal schema failed: %v", err)
+	doc := string(data)
+	for _, want := range []string{
+		`"$ref":"#/$defs/myrpc.schemaArgs"`, `"additionalProperties":false`, `"tags":{"items":{"type":"string"},"type":["array","null"]}`,
+		`"limits":{"type":["object","null"],"additionalProperties":{"minimum":0,"type":"integer"}}`,
+		`"at":{"format":"date-time","type":"string"}`, `"count":{"type":"string"}`,
+		`"child":{"anyOf":[{"$ref":"#/$defs/myrpc.schemaArgs"},{"type":"null"}]}`, `"myrpc.Args":{"properties":{"Num1":{"type":"integer"}`,
+	} {
+		_assert(strings.Contains(doc, want), "schema should contain %s: %s", want, doc)
+	}
+	_assert(!strings.Contains(doc, "Skipped") && !strings.Contains(doc, "hidden"), "ignored fields in schema: %s", doc)
+	var decoded Schema
+	_assert(json.Unmarshal(data, &decoded) == nil && reflect.DeepEqual(&decoded, s), "schema doesn't round trip")
+
+	valid := `{"name":"a","tags":null,"limits":{"x":1},"at":"2024-01-02T03:04:05Z","count":"7","child":{"name":"b"},"pair":[1,2]}`
+	_assert(s.Validate([]byte(valid)) == nil, "valid document rejected: %v", s.Validate([]byte(valid)))
+	for doc, want := range map[string]string{
+		`{"name":1}`:                     "$.name: expect string, got number",
+		`{"limits":{"x":-1}}`:            "$.limits.x: -1 is less than 0",
+		`{"child":{"child":{"at":"x"}}}`: `$.child.child.at: invalid date-time "x"`,
+		`{"pair":[1]}`:                   "$.pair: unexpected array length 1",
+		`{"nope":1}`:                     `$: unknown field "nope"`,
+		`[]`:                             "$: expect object, got array",
+	} {
+		err := s.Validate([]byte(doc))
+		_assert(err != nil && err.Error() == want, "validate %s: expect %q, got %v", doc, want, err)
+	}
+
+	server := newFooServer()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/schema") {
+			server.SchemaHandler().ServeHTTP(w, req)
+			return
+		}
+		server.GatewayHandler().ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/Foo/Sum", "application/json", strings.NewReader(`{"Num1":"1"}`))
+	_assert(err == nil, "post failed: %v", err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "$.Num1: expect integer, got string"),
+		"unexpected gateway response %d %s", resp.StatusCode, body)
+	resp, err = http.Get(ts.URL + "/schema?method=Foo.Swap")
+	_assert(err == nil, "get failed: %v", err)
+	var ms MethodSchema
+	_ = json.NewDecoder(resp.Body).Decode(&ms)
+	resp.Body.Close()
+	_assert(ms.Reply != nil && ms.Reply.Defs["myrpc.Args"].Properties["Num2"].Type == "integer", "unexpected schema %+v", ms.Reply)
+
+	lis, _ := ListenMem("schema", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "schema")
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	var viaRPC MethodSchema
+	err = client.Call(context.Background(), "_rpc.Schema", "Foo.Sum", &viaRPC)
+	_assert(err == nil && viaRPC.Reply.Type == "integer" && viaRPC.Arg.Defs["myrpc.Args"].Closed, "_rpc.Schema failed: %+v %v", viaRPC, err)
+}
+
+func TestVersions(t *testing.T) {
+	var foo Foo
+	var baz Baz
+	server := NewServer()
+	_assert(server.RegisterName("Foo@v1", &foo) == nil, "register Foo@v1 failed")
+	_assert(server.RegisterName("Foo@v1.10", &baz) == nil, "register Foo@v1.10 failed")
+	_assert(server.RegisterName("Foo@", &foo) != nil && server.RegisterName("Foo@v1@v2", &foo) != nil, "invalid versions should fail")
+	lis, _ := ListenMem("versions", 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", "versions")
+	_assert(err == nil, "dial failed: %v", err)
+	defer client.Close()
+	sum := func(ctx context.Context, serviceMethod string) int {
+		var reply int
+		err := client.Call(ctx, serviceMethod, Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil, "call %s failed: %v", serviceMethod, err)
+		return reply
+	}
+	ctx := context.Background()
+	_assert(sum(ctx, "Foo@v1.Sum") == 3 && sum(ctx, "Foo@v1.10.Sum") == 103, "explicit versions should be honored")
+	_assert(sum(ctx, "Foo.Sum") == 103, "the highest version should be the default")
+	_assert(sum(WithVersion(ctx, "v1"), "Foo.Sum") == 3, "the header version should be honored")
+	_assert(sum(WithVersion(ctx, "v1"), "Foo@v1.10.Sum") == 103, "the version in the name should win")
+	_assert(server.SetDefaultVersion("Foo", "v1") == nil && sum(ctx, "Foo.Sum") == 3, "explicit default should be honored")
+	_assert(server.Unregister("Foo@v1") == nil && sum(ctx, "Foo.Sum") == 103, "default should fall back to the remaining version")
+	_assert(server.RegisterName("Foo", &foo) == nil && sum(ctx, "Foo.Sum") == 3, "an unversioned service should win")
+
+	addr, err := server.Advertise("tcp@127.0.0.1:9999")
+	_assert(err == nil && addr == "tcp@127.0.0.1:9999?services=Foo+Foo@v1.10", "unexpected advertised address %s %v", addr, err)
+	a, _ := ParseAddr(addr)
+	_assert(a.Offers("Foo") && a.Offers("Foo@v1.10") && !a.Offers("Foo@v1") && !a.Offers("Bar"), "unexpected offers %v", a.Services)
+	_assert(compareVersions("v10", "v9") > 0 && compareVersions("v1.2", "v1.10") < 0 && compareVersions("v2", "v2.0") < 0, "unexpected version order")
+}
+
+// benchmarkCall calls serviceMethod from parallelism goroutines per CPU.
+func benchmarkCall(b *testing.B, ct codec.Type, serviceMethod string, parallelism int) {
+	server := newFooServer()
+	_ = server.Register(new(Calc))
+	name := fmt.Sprintf("bench-%s-%d", serviceMethod, b.N)
+	lis, _ := ListenMem(name, 0)
+	go server.Accept(lis)
+	defer lis.Close()
+	client, err := Dial("mem", name, &Option{CodeType: ct})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	b.ReportAllocs()
+	b.SetParallelism(parallelism)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			if err := client.Call(context.Background(), serviceMethod, Args{Num1: 1, Num2: 2}, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkCallGob(b *testing.B)             { benchmarkCall(b, codec.GobType, "Foo.Sum", 1) }
+func BenchmarkCallMsgpack(b *testing.B)         { benchmarkCall(b, codec.MsgpackType, "Foo.Sum", 1) }
+func BenchmarkCallReturnsReplyGob(b *testing.B) { benchmarkCall(b, codec.GobType, "Calc.Mul", 1) }
+func BenchmarkCallGob2000(b *testing.B)         { benchmarkCall(b, codec.GobType, "Foo.Sum", 2000) }
+
+// countingConn counts the writes reaching the connection, one per flush.
+type countingConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestConnWriter(t *testing.T) {
+	client, server := net.Pipe()
+	w := newConnWriter(codec.NewGobCodec(server))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = w.Write(&codec.Header{ServeiceMethod: "Foo.Sum", Seq: uint64(i)}, i)
+		}(i)
+	}
+	cc := codec.NewGobCodec(client)
+	seen := make(map[uint64]bool)
+	for i := 0; i < 20; i++ {
+		var h codec.Header
+		var body int
+		_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&body) == nil, "read message %d", i)
+		_assert(uint64(body) == h.Seq && !seen[h.Seq], "unexpected message %d: %+v", body, h)
+		seen[h.Seq] = true
+	}
+	wg.Wait()
+	w.Close()
+	_assert(errors.Is(w.Write(&codec.Header{}, 0), Errshutdown), "write after close should fail")
+}
+
+// benchmarkConnWrite writes from many goroutines, newWrite returns the write
+// function under test and a function stopping it.
+func benchmarkConnWrite(b *testing.B, newWrite func(codec.Codec) (func(*codec.Header, interface{}) error, func())) {
+	//写入真实的 TCP 连接，每次 flush 都是一次系统调用
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		if peer, err := lis.Accept(); err == nil {
+			_, _ = io.Copy(io.Discard, peer)
+		}
+	}()
+	c, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	conn := &countingConn{Conn: c}
+	write, stop := newWrite(codec.NewGobCodec(conn))
+	defer stop()
+	b.ReportAllocs()
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		h := &codec.Header{ServeiceMethod: "Foo.Sum"}
+		for pb.Next() {
+			if err := write(h, &Args{Num1: 1, Num2: 2}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(atomic.LoadInt64(&conn.writes))/float64(b.N), "flushes/op")
+}
+
+func BenchmarkConnWrite(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		benchmarkConnWrite(b, func(cc codec.Codec) (func(*codec.Header, interface{}) error, func()) {
+			var mu sync.Mutex
+			return func(h *codec.Header, body interface{}) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return cc.Write(h, body)
+			}, func() {}
+		})
+	})
+	b.Run("writer", func(b *testing.B) {
+		benchmarkConnWrite(b, func(cc codec.Codec) (func(*codec.Header, interface{}) error, func()) {
+			w := newConnWriter(cc)
+			return w.Write, w.Close
+		})
+	})
+}
@@ -1,17 +1,130 @@
 package myrpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 )
 
 type methodType struct {
 	method    reflect.Method
-	ArgType   reflect.Type //第一个参数的类型
-	ReplyType reflect.Type //第2个参数的类型
-	NumCalls  uint64       //后续统计方法调用次数时会用到
+	ArgType   reflect.Type  //第一个参数的类型
+	ReplyType reflect.Type  //第2个参数的类型
+	NumCalls  uint64        //后续统计方法调用次数时会用到
+	fn        reflect.Value //被调用的函数，方法为 method.Func，HandleFunc 注册的函数为其本身
+	rcvr      reflect.Value //方法的接收者，函数没有
+	withCtx   bool          //第一个参数是 context.Context
+	returns   bool          //以返回值 (reply, error) 代替 reply 参数，ReplyType 仍是指针类型
+
+	//以下缓存用于减少每次调用的内存分配
+	inPool    sync.Pool //*[]reflect.Value，调用 fn 的参数，接收者已就位
+	argPool   sync.Pool //*T，仅在 ArgType 不是指针时使用
+	replyPool sync.Pool //*R，仅在 returns 为 true 时使用
+
+	schemaOnce sync.Once
+	schema     *MethodSchema //参数和返回值的 JSON Schema，首次使用时生成
+}
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// newMethodType checks the signature of a method or function, in is the index
+// of the first parameter after the receiver: 0 for functions, 1 for methods.
+// Accepted signatures, each optionally taking a context.Context first, are
+// (args T, reply *R) error and (args T) (R, error).
+func newMethodType(ft reflect.Type, in int) (*methodType, error) {
+	returns := ft.NumOut() == 2
+	if ft.NumOut() < 1 || ft.NumOut() > 2 || ft.Out(ft.NumOut()-1) != typeOfError {
+		return nil, errors.New("must return error or (reply, error)")
+	}
+	params := 2
+	if returns {
+		params = 1
+	}
+	withCtx := ft.NumIn() == in+params+1 && ft.In(in) == typeOfContext
+	if withCtx {
+		in++
+	}
+	if ft.NumIn() != in+params && returns {
+		return nil, errors.New("returning (reply, error) must take (args) or (context.Context, args)")
+	} else if ft.NumIn() != in+params {
+		return nil, errors.New("must take (args, *reply) or (context.Context, args, *reply)")
+	}
+	var argType, replyType reflect.Type
+	if returns {
+		argType, replyType = ft.In(in), reflect.PtrTo(ft.Out(0))
+	} else {
+		argType, replyType = ft.In(in), ft.In(in+1)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	if !IsExportedOrBuiltInType(argType) {
+		return nil, fmt.Errorf("argument type %s is not exported", argType)
+	}
+	if !IsExportedOrBuiltInType(replyType) {
+		return nil, fmt.Errorf("reply type %s is not exported", replyType)
+	}
+	return &methodType{ArgType: argType, ReplyType: replyType, withCtx: withCtx, returns: returns}, nil
+}
+
+// callArgs returns a slice for the arguments of fn with the receiver in place.
+func (m *methodType) callArgs() *[]reflect.Value {
+	if p, ok := m.inPool.Get().(*[]reflect.Value); ok {
+		return p
+	}
+	in := make([]reflect.Value, m.fn.Type().NumIn())
+	if m.rcvr.IsValid() {
+		in[0] = m.rcvr
+	}
+	return &in
+}
+
+// getArgV is like newArgV but reuses the values given back by putArgV. Only
+// non-pointer arguments are pooled: the method gets a copy of them and can't
+// keep a reference to the pooled value.
+func (m *methodType) getArgV() reflect.Value {
+	if m.ArgType.Kind() != reflect.Ptr {
+		if p := m.argPool.Get(); p != nil {
+			return reflect.ValueOf(p).Elem()
+		}
+	}
+	return m.newArgV()
+}
+
+func (m *methodType) putArgV(argv reflect.Value) {
+	if m.ArgType.Kind() != reflect.Ptr && argv.IsValid() {
+		argv.Set(reflect.Zero(m.ArgType))
+		m.argPool.Put(argv.Addr().Interface())
+	}
+}
+
+// getReplyV is like newReplyV but reuses the replies of the methods returning
+// them, the method never sees the pooled value.
+func (m *methodType) getReplyV() reflect.Value {
+	if m.returns {
+		if p := m.replyPool.Get(); p != nil {
+			return reflect.ValueOf(p)
+		}
+	}
+	return m.newReplyV()
+}
+
+// putReplyV must only be called once the reply is encoded.
+func (m *methodType) putReplyV(replyv reflect.Value) {
+	if m.returns && replyv.IsValid() {
+		replyv.Elem().Set(reflect.Zero(m.ReplyType.Elem()))
+		m.replyPool.Put(replyv.Interface())
+	}
 }
 
 func (m *methodType) newArgV() reflect.Value {
@@ -46,57 +159,222 @@ type service struct {
 	typ    reflect.Type
 	rcvr   reflect.Value //结构体的实例本身
 	method map[string]*methodType
+	raw    RawHandler   //非空时为 raw 服务，没有 method
+	calls  *callTracker //正在执行的调用，注销时等待它们返回
+
+	skipped []SkippedMethod //注册时被跳过的导出方法
+}
+
+// SkippedMethod is an exported method of a receiver that couldn't be published.
+type SkippedMethod struct {
+	Name   string
+	Reason string
+}
+
+// RegisterReport describes what registering a receiver published and skipped.
+type RegisterReport struct {
+	Service string
+	Methods []string
+	Skipped []SkippedMethod
+}
+
+// Err returns a *RegisterError when methods were skipped or none was published.
+func (r *RegisterReport) Err() error {
+	if len(r.Skipped) == 0 && len(r.Methods) > 0 {
+		return nil
+	}
+	return &RegisterError{Service: r.Service, Skipped: r.Skipped}
+}
+
+// RegisterError is returned by the registrations of a strict Server.
+type RegisterError struct {
+	Service string
+	Skipped []SkippedMethod
+}
+
+func (e *RegisterError) Error() string {
+	if len(e.Skipped) == 0 {
+		return "rpc: service " + e.Service + " has no exported methods of suitable type"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "rpc: service %s skipped %d method(s):", e.Service, len(e.Skipped))
+	for i, m := range e.Skipped {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		fmt.Fprintf(&sb, " %s %s", m.Name, m.Reason)
+	}
+	return sb.String()
 }
 
+func (s *service) report() *RegisterReport {
+	r := &RegisterReport{Service: s.name, Skipped: s.skipped}
+	for name := range s.method {
+		r.Methods = append(r.Methods, name)
+	}
+	sort.Strings(r.Methods)
+	return r
+}
+
+// callTracker counts the running calls of a service so that it can be drained,
+// it's shared by the successive versions of a service built by HandleFunc.
+type callTracker struct {
+	mu       sync.Mutex
+	inflight sync.WaitGroup
+	removed  bool
+}
+
+// NewService is like newService but exits when rcvr can't be a service.
 func NewService(rcvr interface{}) *service {
+	s, err := newService("", rcvr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
 
-	s := new(service)
+// newService builds the service of rcvr, named after its type when name is empty.
+func newService(name string, rcvr interface{}) (*service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: nil receiver")
+	}
+	s := &service{calls: new(callTracker)}
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-
-	//log.Println(s.rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if name == "" {
+		t := s.typ
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		name = t.Name()
+		if !ast.IsExported(name) {
+			return nil, fmt.Errorf("rpc server: %q is not a valid service name", name)
+		}
 	}
+	if err := checkServiceName(name); err != nil {
+		return nil, err
+	}
+	s.name = name
 	s.registerMethods()
-	return s
+	return s, nil
 }
 
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !IsExportedOrBuiltInType(argType) || !IsExportedOrBuiltInType(replyType) {
+		mType, err := newMethodType(method.Type, 1)
+		if err != nil {
+			s.skip(method.Name, err.Error())
 			continue
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
+		mType.method = method
+		mType.fn, mType.rcvr = method.Func, s.rcvr
+		s.method[method.Name] = mType
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
+	//值类型的接收者看不到指针接收者的方法
+	if s.typ.Kind() != reflect.Ptr {
+		ptr := reflect.PtrTo(s.typ)
+		for i := 0; i < ptr.NumMethod(); i++ {
+			method := ptr.Method(i)
+			if _, ok := s.typ.MethodByName(method.Name); !ok {
+				s.skip(method.Name, "has a pointer receiver, register a pointer instead")
+			}
+		}
+	}
+}
+
+func (s *service) skip(name, reason string) {
+	s.skipped = append(s.skipped, SkippedMethod{Name: name, Reason: reason})
+	log.Printf("rpc server: skip %s.%s: %s\n", s.name, name, reason)
 }
 
 func IsExportedOrBuiltInType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
+// drainedError is returned by the calls to a service after it's unregistered or replaced.
+type drainedError struct {
+	name string
+}
+
+func (e *drainedError) Error() string {
+	return fmt.Sprintf("%v %s: unregistered", ErrServiceNotFound, e.name)
+}
+
+func (e *drainedError) Unwrap() error { return ErrServiceNotFound }
+
+// acquire fails once the service is unregistered, otherwise release must follow.
+func (s *service) acquire() error {
+	c := s.calls
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.removed {
+		return &drainedError{s.name}
+	}
+	c.inflight.Add(1)
+	return nil
+}
+
+func (s *service) release() {
+	s.calls.inflight.Done()
+}
+
+// drain rejects new calls and waits for the running ones to return.
+func (s *service) drain() {
+	c := s.calls
+	c.mu.Lock()
+	c.removed = true
+	c.mu.Unlock()
+	c.inflight.Wait()
+}
+
+func (s *service) serveRaw(req *RawRequest) (interface{}, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	return s.raw.ServeRaw(req)
+}
+
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext passes ctx to the methods taking a context.Context.
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
 	atomic.AddUint64(&m.NumCalls, 1)
-	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
-	if errInter := returnValues[0].Interface(); errInter != nil {
+	p := m.callArgs()
+	in := *p
+	i := 0
+	if m.rcvr.IsValid() {
+		i++
+	}
+	first := i
+	if m.withCtx {
+		in[i] = reflect.ValueOf(ctx)
+		i++
+	}
+	in[i] = argv
+	if !m.returns {
+		in[i+1] = replyv
+	}
+	returnValues := m.fn.Call(in)
+	//放回池中前清掉参数，避免池中的切片持有它们
+	for j := first; j < len(in); j++ {
+		in[j] = reflect.Value{}
+	}
+	m.inPool.Put(p)
+	if errInter := returnValues[len(returnValues)-1].Interface(); errInter != nil {
 		return errInter.(error)
 	}
+	if m.returns {
+		replyv.Elem().Set(returnValues[0])
+	}
 	return nil
 }
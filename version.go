@@ -0,0 +1,174 @@
+package myrpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Several versions of a service are registered side by side under the names
+// "Foo@v1", "Foo@v2", see RegisterName. A request picks a version with the
+// "Foo@v2.Sum" syntax or the Version field of its header (WithVersion on the
+// client side). A request for plain "Foo.Sum" goes to the service registered
+// as "Foo" if any, otherwise to the default version of Foo: the one set with
+// SetDefaultVersion, or else the highest version.
+
+// splitVersion splits "Foo@v2" into "Foo" and "v2".
+func splitVersion(name string) (string, string) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// compareVersions compares the dot separated parts of a and b, numerically
+// when both parts are numbers, a leading "v" is ignored.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// lookupService finds a service by name, applying the default version rules.
+func (server *Server) lookupService(name string) (*service, bool) {
+	if svci, ok := server.serviceMap.Load(name); ok {
+		return svci.(*service), true
+	}
+	if strings.Contains(name, "@") {
+		return nil, false
+	}
+	key, ok := server.defaults.Load(name)
+	if !ok {
+		return nil, false
+	}
+	svci, ok := server.serviceMap.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return svci.(*service), true
+}
+
+// versions returns the registered versions of base, sorted.
+func (server *Server) versions(base string) []string {
+	var versions []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		if b, v := splitVersion(namei.(string)); b == base && v != "" {
+			versions = append(versions, v)
+		}
+		return true
+	})
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions
+}
+
+// updateDefault recomputes the default version of the service named name,
+// server.regMu must be held.
+func (server *Server) updateDefault(name string) {
+	base, _ := splitVersion(name)
+	versions := server.versions(base)
+	if len(versions) == 0 {
+		server.defaults.Delete(base)
+		return
+	}
+	version := versions[len(versions)-1]
+	if v, ok := server.defaultVersions[base]; ok {
+		for _, registered := range versions {
+			if registered == v {
+				version = v
+			}
+		}
+	}
+	server.defaults.Store(base, base+"@"+version)
+}
+
+// SetDefaultVersion routes the requests for name without a version to the given
+// version, which may be registered later. An empty version restores the highest one.
+func (server *Server) SetDefaultVersion(name, version string) error {
+	if strings.Contains(name, "@") || strings.Contains(version, "@") {
+		return errors.New("rpc: SetDefaultVersion takes the name and the version separately")
+	}
+	server.regMu.Lock()
+	defer server.regMu.Unlock()
+	if version == "" {
+		delete(server.defaultVersions, name)
+	} else {
+		if server.defaultVersions == nil {
+			server.defaultVersions = make(map[string]string)
+		}
+		server.defaultVersions[name] = version
+	}
+	server.updateDefault(name)
+	return nil
+}
+
+func SetDefaultVersion(name, version string) error {
+	return DefaultServer.SetDefaultVersion(name, version)
+}
+
+// withVersion adds version to the service of serviceMethod unless it names one already.
+func withVersion(serviceMethod, version string) string {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if version == "" || dot < 0 || strings.Contains(serviceMethod[:dot], "@") {
+		return serviceMethod
+	}
+	return serviceMethod[:dot] + "@" + version + serviceMethod[dot:]
+}
+
+// ServiceNames returns the names of the registered services, versions included,
+// without the reflection service.
+func (server *Server) ServiceNames() []string {
+	var names []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		if name := namei.(string); name != ReflectionService {
+			names = append(names, name)
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Advertise adds the services of server to addr, so that discovery can tell which
+// servers offer a service version, e.g. "tcp@10.0.0.1:9999?services=Foo@v1+Foo@v2".
+func (server *Server) Advertise(addr string) (string, error) {
+	a, err := ParseAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	a.Services = server.ServiceNames()
+	return a.String(), nil
+}
+
+type versionKey struct{}
+
+// WithVersion makes Client.Call request the given version of the service,
+// unless the service method names one already.
+func WithVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// VersionFrom returns the version set by WithVersion, "" when there is none.
+func VersionFrom(ctx context.Context) string {
+	v, _ := ctx.Value(versionKey{}).(string)
+	return v
+}
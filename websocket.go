@@ -0,0 +1,325 @@
+package myrpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The WebSocket transport carries the usual RPC byte stream (Option handshake
+// followed by codec messages) inside binary WebSocket frames, see RFC 6455.
+// Message boundaries don't matter, the frames are read as one continuous stream.
+
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultWSPath   = "/_geerpc_/ws"
+	wsMaxFrameSize  = 64 << 20
+	wsOpContinue    = 0x0
+	wsOpText        = 0x1
+	wsOpBinary      = 0x2
+	wsOpClose       = 0x8
+	wsOpPing        = 0x9
+	wsOpPong        = 0xa
+	wsFinalBit      = 0x80
+	wsRSVBits       = 0x70
+	wsMaskBit       = 0x80
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+)
+
+var errWSProtocol = errors.New("rpc websocket: protocol error")
+
+func wsAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+wsGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsConn is a net.Conn reading and writing the payload of WebSocket frames.
+// Clients mask the frames they send, servers require masked frames.
+type wsConn struct {
+	net.Conn
+	r        *bufio.Reader
+	client   bool
+	wmu      sync.Mutex
+	remain   uint64 //当前数据帧剩余未读的长度
+	mask     [4]byte
+	masked   bool
+	maskPos  int
+	closed   bool
+	closeErr error
+}
+
+func newWSConn(conn net.Conn, r *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, r: r, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		if c.closeErr != nil {
+			return 0, c.closeErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.closeErr = err
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.r.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remain -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with a payload, answering control frames.
+func (c *wsConn) nextFrame() error {
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return err
+	}
+	op := h[0] & 0x0f
+	if h[0]&wsRSVBits != 0 || (op >= wsOpClose && h[0]&wsFinalBit == 0) {
+		//没有协商扩展，RSV 位必须为 0；控制帧不能分片
+		c.fail()
+		return errWSProtocol
+	}
+	c.masked = h[1]&wsMaskBit != 0
+	if c.masked == c.client {
+		//客户端发送的帧必须带掩码，服务端发送的帧不能带掩码
+		c.fail()
+		return errWSProtocol
+	}
+	size := uint64(h[1] & 0x7f)
+	switch size {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		size = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	}
+	if size > wsMaxFrameSize || (op >= wsOpClose && size > 125) {
+		c.fail()
+		return errWSProtocol
+	}
+	c.maskPos = 0
+	if c.masked {
+		if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	switch op {
+	case wsOpContinue, wsOpText, wsOpBinary:
+		c.remain = size
+		return nil
+	case wsOpPing, wsOpPong, wsOpClose:
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return err
+		}
+		if c.masked {
+			for i := range payload {
+				payload[i] ^= c.mask[i&3]
+			}
+		}
+		switch op {
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.sendClose(wsCloseNormal)
+			return io.EOF
+		}
+		return nil
+	}
+	c.fail()
+	return errWSProtocol
+}
+
+func (c *wsConn) fail() {
+	c.sendClose(wsCloseProtocol)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if op == wsOpClose {
+		c.closed = true
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, wsFinalBit|op)
+	var maskBit byte
+	if c.client {
+		maskBit = wsMaskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		frame = append(append(frame, maskBit|127), b[:]...)
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) sendClose(code uint16) {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+	_ = c.writeFrame(wsOpClose, payload[:])
+}
+
+func (c *wsConn) Close() error {
+	c.sendClose(wsCloseNormal)
+	return c.Conn.Close()
+}
+
+type wsHTTP struct {
+	*Server
+}
+
+// WebSocketHandler upgrades requests to WebSocket and serves RPC over them.
+// Upgrades failing Server.CheckOrigin are answered 403, so that pages from
+// other origins can't drive the server through a visitor's browser.
+func (server *Server) WebSocketHandler() http.Handler {
+	return &wsHTTP{server}
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin accepts requests without Origin, as sent by non-browser clients,
+// and those whose Origin host is the requested host.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+func (server *wsHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "400 websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	checkOrigin := server.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		http.Error(w, "403 origin not allowed", http.StatusForbidden)
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "426 unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	con, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		return
+	}
+	_, err = io.WriteString(con, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+wsAccept(key)+"\r\n\r\n")
+	if err != nil {
+		con.Close()
+		return
+	}
+	server.ServerCon(newWSConn(con, buf.Reader, false))
+}
+
+// newWSClient performs the WebSocket handshake for host and path, then creates a Client on top of it.
+func newWSClient(host, path string) newClientFunc {
+	return func(con net.Conn, opt *Option) (*Client, error) {
+		var nonce [16]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return nil, err
+		}
+		key := base64.StdEncoding.EncodeToString(nonce[:])
+		req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, host, key)
+		if _, err := io.WriteString(con, req); err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(con)
+		response, err := http.ReadResponse(r, &http.Request{Method: http.MethodGet})
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+			return nil, errors.New("unexpected websocket handshake response: " + response.Status)
+		}
+		return NewClient(newWSConn(con, r, true), opt)
+	}
+}
+
+// DialWebSocket connects to an RPC server through the WebSocket handler mounted at path.
+func DialWebSocket(network, address, path string, opts ...*Option) (*Client, error) {
+	return dialTimeout(newWSClient(address, path), network, address, opts...)
+}
@@ -0,0 +1,141 @@
+package myrpc
+
+import (
+	"myrpc/codec"
+	"runtime"
+	"sync"
+)
+
+// connWriter owns the writes of a connection. Write queues a message and waits
+// for a dedicated goroutine, which writes everything queued so far with
+// codec.WriteBatch. An idle connection writes each message right away, under
+// load the messages queued during a flush go out together in the next one.
+type connWriter struct {
+	cc      codec.Codec
+	mu      sync.Mutex
+	written *sync.Cond //每写完一批广播一次
+	hs      []*codec.Header
+	bodies  []interface{}
+	gen     uint64 //正在排队的一批的编号，从 1 开始
+	done    uint64 //已写完的最后一批的编号
+	err     error  //第一次写出错的错误，编解码器此时已关闭连接
+	errGen  uint64 //出错的那一批，它和之后的批次都返回 err
+	closed  bool
+	wake    chan struct{}
+}
+
+func newConnWriter(cc codec.Codec) *connWriter {
+	w := &connWriter{cc: cc, gen: 1, wake: make(chan struct{}, 1)}
+	w.written = sync.NewCond(&w.mu)
+	go w.loop()
+	return w
+}
+
+// Write writes a message and returns once it's flushed.
+func (w *connWriter) Write(h *codec.Header, body interface{}) error {
+	w.mu.Lock()
+	if err := w.check(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	w.hs = append(w.hs, h)
+	w.bodies = append(w.bodies, body)
+	return w.wait()
+}
+
+// WriteBatch writes the messages next to each other, as codec.WriteBatch does.
+func (w *connWriter) WriteBatch(hs []*codec.Header, bodies []interface{}) error {
+	w.mu.Lock()
+	if err := w.check(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	w.hs = append(w.hs, hs...)
+	w.bodies = append(w.bodies, bodies...)
+	return w.wait()
+}
+
+// check tells whether messages can still be queued, w.mu must be held.
+func (w *connWriter) check() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return Errshutdown
+	}
+	return nil
+}
+
+// wait wakes the writing goroutine and waits for the batch just joined,
+// w.mu must be held and is released.
+func (w *connWriter) wait() error {
+	gen := w.gen
+	select {
+	case w.wake <- struct{}{}:
+	default:
+		//写协程已被唤醒，会取走这一批
+	}
+	for w.done < gen {
+		w.written.Wait()
+	}
+	var err error
+	if w.err != nil && gen >= w.errGen {
+		err = w.err
+	}
+	w.mu.Unlock()
+	return err
+}
+
+func (w *connWriter) loop() {
+	//两组切片轮流使用，写的同时可以继续排队
+	var hs []*codec.Header
+	var bodies []interface{}
+	for range w.wake {
+		if len(hs) > 0 {
+			//刚写完一批，说明有负载：让出处理器，让被唤醒的调用方先把下一批排好
+			runtime.Gosched()
+		}
+		w.mu.Lock()
+		gen, failed, closed := w.gen, w.err != nil, w.closed
+		hs, w.hs = w.hs, hs[:0]
+		bodies, w.bodies = w.bodies, bodies[:0]
+		if len(hs) > 0 {
+			w.gen++
+		}
+		w.mu.Unlock()
+		if len(hs) > 0 {
+			var err error
+			if !failed {
+				err = codec.WriteBatch(w.cc, hs, bodies)
+			}
+			w.mu.Lock()
+			if err != nil {
+				w.err, w.errGen = err, gen
+			}
+			w.done = gen
+			w.mu.Unlock()
+			w.written.Broadcast()
+			//清掉引用，避免持有已写出的消息
+			for i := range hs {
+				hs[i], bodies[i] = nil, nil
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// Close stops the writer once the queued messages are written, it doesn't close the codec.
+func (w *connWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"myrpc"
 	"sync"
 	"time"
 )
@@ -43,6 +44,9 @@ func (d *MultiServerDiscovery) Refresh() error {
 	return nil
 }
 func (d *MultiServerDiscovery) Update(servers []string) error {
+	if err := checkServers(servers); err != nil {
+		return err
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
@@ -51,15 +55,26 @@ func (d *MultiServerDiscovery) Update(servers []string) error {
 func (d *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.servers)
+	return d.pick(mode, d.servers)
+}
+
+// GetFor is like Get but only selects among the servers offering service.
+func (d *MultiServerDiscovery) GetFor(mode SelectMode, service string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pick(mode, offering(d.servers, service))
+}
+
+func (d *MultiServerDiscovery) pick(mode SelectMode, servers []string) (string, error) {
+	n := len(servers)
 	if n == 0 {
 		return "", errors.New("rpc discovery: no available servers")
 	}
 	switch mode {
 	case RandomSelect:
-		return d.servers[d.r.Intn(n)], nil
+		return servers[d.r.Intn(n)], nil
 	case RondRobinSelect:
-		ret := d.servers[d.index%n]
+		ret := servers[d.index%n]
 		d.index = (d.index + 1) % n
 		return ret, nil
 	default:
@@ -73,3 +88,31 @@ func (d *MultiServerDiscovery) GetAll() ([]string, error) {
 	copy(servers, d.servers)
 	return servers, nil
 }
+
+// ServiceDiscovery is a Discovery able to select among the servers offering
+// a service, as advertised in their address (see myrpc.Server.Advertise).
+type ServiceDiscovery interface {
+	Discovery
+	GetFor(mode SelectMode, service string) (string, error)
+}
+
+// offering keeps the servers whose address offers service.
+func offering(servers []string, service string) []string {
+	var res []string
+	for _, server := range servers {
+		if addr, err := myrpc.ParseAddr(server); err == nil && addr.Offers(service) {
+			res = append(res, server)
+		}
+	}
+	return res
+}
+
+// checkServers makes sure every address can be dialed by XDial.
+func checkServers(servers []string) error {
+	for _, server := range servers {
+		if _, err := myrpc.ParseAddr(server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
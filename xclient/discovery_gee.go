@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"log"
+	"myrpc"
 	"net/http"
 	"strings"
 	"time"
@@ -39,14 +40,24 @@ func (d *GeeRegisterDiscovery) Refresh() error {
 	servers := strings.Split(rep.Header.Get("X-Geerpc-Servers"), ",")
 	d.servers = make([]string, 0, len(servers))
 	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			servers = append(servers, strings.TrimSpace(server))
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
 		}
+		//跳过无法解析的地址，不影响其他服务实例
+		if _, err := myrpc.ParseAddr(server); err != nil {
+			log.Println("rpc registry: skip server:", err)
+			continue
+		}
+		d.servers = append(d.servers, server)
 	}
 	d.lastUpdate = time.Now()
 	return nil
 }
 func (d *GeeRegisterDiscovery) Update(servers []string) error {
+	if err := checkServers(servers); err != nil {
+		return err
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
@@ -60,6 +71,12 @@ func (d *GeeRegisterDiscovery) Get(mode SelectMode) (string, error) {
 	}
 	return d.MultiServerDiscovery.Get(mode)
 }
+func (d *GeeRegisterDiscovery) GetFor(mode SelectMode, service string) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServerDiscovery.GetFor(mode, service)
+}
 func (d *GeeRegisterDiscovery) GetAll() ([]string, error) {
 	if err := d.Refresh(); err != nil {
 		return nil, err
@@ -0,0 +1,213 @@
+package xclient
+
+import (
+	"context"
+	"myrpc"
+	"sync"
+	"time"
+)
+
+// PoolOption sizes the clients XClient keeps to each address. A client
+// multiplexes calls, the pool dials another one when every client has calls in
+// flight, up to MaxConns. Clients idle for IdleTimeout are closed, down to MinConns.
+type PoolOption struct {
+	MinConns    int           //每个地址保持的最少连接数，提前在后台建立
+	MaxConns    int           //每个地址的最大连接数，小于 1 时按 1 处理
+	IdleTimeout time.Duration //为 0 时不回收空闲连接
+}
+
+// DefaultPoolOption keeps a single client per address, as XClient always did.
+var DefaultPoolOption = &PoolOption{MaxConns: 1}
+
+type pooledClient struct {
+	*myrpc.Client
+	inflight int //借出未归还的次数
+	lastUsed time.Time
+}
+
+// pool holds the clients of one address. Dials run in the background without
+// holding mu, the callers finding no usable client wait for the next dial to finish.
+type pool struct {
+	addr    string
+	opt     *myrpc.Option
+	popt    *PoolOption
+	mu      sync.Mutex
+	clients []*pooledClient
+	dialing int
+	dialed  chan struct{} //每次拨号结束时关闭并换新
+	err     error         //最近一次拨号的错误
+	closed  bool
+}
+
+func newPool(addr string, opt *myrpc.Option, popt *PoolOption) *pool {
+	p := &pool{addr: addr, opt: opt, popt: popt, dialed: make(chan struct{})}
+	p.mu.Lock()
+	p.fill()
+	p.mu.Unlock()
+	return p
+}
+
+func (p *pool) maxConns() int {
+	if p.popt.MaxConns < 1 {
+		return 1
+	}
+	return p.popt.MaxConns
+}
+
+// get checks out the healthy client with the fewest calls in flight, it must
+// be given back with put.
+func (p *pool) get(ctx context.Context) (*pooledClient, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, myrpc.Errshutdown
+		}
+		if pc := p.pick(); pc != nil {
+			if pc.inflight > 0 && len(p.clients)+p.dialing < p.maxConns() {
+				//所有连接都在忙，后台再建一条
+				p.dial()
+			}
+			pc.inflight++
+			pc.lastUsed = time.Now()
+			p.mu.Unlock()
+			return pc, nil
+		}
+		if p.dialing == 0 {
+			p.dial()
+		}
+		dialed := p.dialed
+		p.mu.Unlock()
+		select {
+		case <-dialed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+		if len(p.clients) == 0 && p.dialing == 0 && p.err != nil {
+			err := p.err
+			p.mu.Unlock()
+			return nil, err
+		}
+	}
+}
+
+// put gives back a client checked out by get, it's closed if the pool was
+// closed in the meantime and no other call uses it.
+func (p *pool) put(pc *pooledClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.inflight--
+	pc.lastUsed = time.Now()
+	if p.closed && pc.inflight == 0 {
+		pc.Close()
+		p.remove(pc)
+	}
+}
+
+// pick drops the broken clients and returns the least busy one, p.mu must be held.
+func (p *pool) pick() *pooledClient {
+	var best *pooledClient
+	clients := p.clients[:0]
+	for _, pc := range p.clients {
+		if !pc.IsAvaliable() {
+			pc.Close()
+			continue
+		}
+		clients = append(clients, pc)
+		if best == nil || pc.inflight < best.inflight {
+			best = pc
+		}
+	}
+	for i := len(clients); i < len(p.clients); i++ {
+		p.clients[i] = nil
+	}
+	p.clients = clients
+	return best
+}
+
+// dial adds a client in the background, p.mu must be held.
+func (p *pool) dial() {
+	p.dialing++
+	go func() {
+		client, err := myrpc.XDial(p.addr, p.opt)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.dialing--
+		p.err = err
+		if err == nil {
+			if p.closed {
+				client.Close()
+			} else {
+				p.clients = append(p.clients, &pooledClient{Client: client, lastUsed: time.Now()})
+			}
+		}
+		close(p.dialed)
+		p.dialed = make(chan struct{})
+	}()
+}
+
+// fill dials up to MinConns clients, p.mu must be held.
+func (p *pool) fill() {
+	for n := len(p.clients) + p.dialing; n < p.popt.MinConns && n < p.maxConns(); n++ {
+		p.dial()
+	}
+}
+
+// evict closes the broken clients and those idle since before deadline, keeping MinConns.
+func (p *pool) evict(deadline time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.pick()
+	clients := p.clients[:0]
+	for i, pc := range p.clients {
+		//保留的加上还没检查的，不能少于 MinConns
+		kept := len(clients) + len(p.clients) - i - 1
+		if p.popt.IdleTimeout > 0 && pc.inflight == 0 && pc.lastUsed.Before(deadline) && kept >= p.popt.MinConns {
+			pc.Close()
+			continue
+		}
+		clients = append(clients, pc)
+	}
+	for i := len(clients); i < len(p.clients); i++ {
+		p.clients[i] = nil
+	}
+	p.clients = clients
+	p.fill()
+}
+
+// remove drops pc from the pool, p.mu must be held.
+func (p *pool) remove(pc *pooledClient) {
+	for i, c := range p.clients {
+		if c == pc {
+			copy(p.clients[i:], p.clients[i+1:])
+			p.clients[len(p.clients)-1] = nil
+			p.clients = p.clients[:len(p.clients)-1]
+			return
+		}
+	}
+}
+
+// close stops handing out clients. The idle ones are closed right away, those
+// checked out are closed by put once their calls are done.
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	clients := p.clients[:0]
+	for _, pc := range p.clients {
+		if pc.inflight == 0 {
+			pc.Close()
+			continue
+		}
+		//还在调用中，留给 put 关闭
+		clients = append(clients, pc)
+	}
+	for i := len(clients); i < len(p.clients); i++ {
+		p.clients[i] = nil
+	}
+	p.clients = clients
+}
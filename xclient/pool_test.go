@@ -0,0 +1,221 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"myrpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+// startServer serves a Slow service on the mem network under name.
+func startServer(t *testing.T, name string) string {
+	server := myrpc.NewServer()
+	if err := server.Register(new(Slow)); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := server.Serve("mem@" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	return addrs[0]
+}
+
+// waitFor polls cond under p.mu until it holds or a second has passed.
+func waitFor(t *testing.T, p *pool, what string, cond func() bool) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		ok := cond()
+		p.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestPoolMinMaxConns(t *testing.T) {
+	p := newPool(startServer(t, "pool-minmax"), nil, &PoolOption{MinConns: 2, MaxConns: 3})
+	defer p.close()
+	waitFor(t, p, "MinConns clients", func() bool { return len(p.clients) == 2 && p.dialing == 0 })
+
+	var got []*pooledClient
+	for i := 0; i < 8; i++ {
+		pc, err := p.get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pc)
+		p.mu.Lock()
+		n := len(p.clients) + p.dialing
+		p.mu.Unlock()
+		if n > 3 {
+			t.Fatalf("pool exceeds MaxConns: %d", n)
+		}
+	}
+	waitFor(t, p, "MaxConns clients", func() bool { return len(p.clients) == 3 && p.dialing == 0 })
+	for _, pc := range got {
+		p.put(pc)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.clients {
+		if pc.inflight != 0 {
+			t.Fatalf("client still checked out %d times", pc.inflight)
+		}
+	}
+}
+
+func TestPoolEvict(t *testing.T) {
+	p := newPool(startServer(t, "pool-evict"), nil, &PoolOption{MinConns: 1, MaxConns: 3, IdleTimeout: time.Hour})
+	defer p.close()
+	//所有连接都借出时才会新建连接，借到连接数满为止
+	var got []*pooledClient
+	for i := 0; i < 10 && len(p.clients) < 3; i++ {
+		pc, err := p.get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pc)
+		waitFor(t, p, "dial", func() bool { return p.dialing == 0 })
+	}
+	if n := len(p.clients); n != 3 {
+		t.Fatalf("expect MaxConns clients, got %d", n)
+	}
+	//最后建立的连接还没借出，get 总是先借出它
+	pc, _ := p.get(context.Background())
+	got = append(got, pc)
+
+	//借出的连接不回收
+	p.evict(time.Now().Add(time.Hour))
+	if n := len(p.clients); n != 3 {
+		t.Fatalf("busy clients evicted, %d left", n)
+	}
+	for _, pc := range got {
+		p.put(pc)
+	}
+	p.evict(time.Now().Add(-time.Minute))
+	if n := len(p.clients); n != 3 {
+		t.Fatalf("recently used clients evicted, %d left", n)
+	}
+	p.evict(time.Now().Add(time.Hour))
+	if n := len(p.clients); n != 1 {
+		t.Fatalf("expect MinConns clients after evict, got %d", n)
+	}
+	closed := make(map[*pooledClient]bool)
+	for _, pc := range got {
+		if !pc.IsAvaliable() {
+			closed[pc] = true
+		}
+	}
+	if len(closed) != 2 {
+		t.Fatalf("expect 2 evicted clients closed, got %d", len(closed))
+	}
+}
+
+func TestPoolConcurrentGet(t *testing.T) {
+	p := newPool(startServer(t, "pool-concurrent"), nil, &PoolOption{MaxConns: 2})
+	defer p.close()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pc, err := p.get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer p.put(pc)
+			var reply int
+			if err := pc.Call(context.Background(), "Slow.Sleep", 1, &reply); err != nil || reply != 1 {
+				t.Errorf("call failed: %d %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, p, "dials", func() bool { return p.dialing == 0 })
+	if n := len(p.clients); n < 1 || n > 2 {
+		t.Fatalf("expect 1 or 2 clients, got %d", n)
+	}
+}
+
+func TestPoolSkipsBroken(t *testing.T) {
+	p := newPool(startServer(t, "pool-broken"), nil, &PoolOption{MinConns: 2, MaxConns: 2})
+	defer p.close()
+	waitFor(t, p, "MinConns clients", func() bool { return len(p.clients) == 2 && p.dialing == 0 })
+	broken, healthy := p.clients[0], p.clients[1]
+	broken.Close()
+	for i := 0; i < 3; i++ {
+		pc, err := p.get(context.Background())
+		if err != nil || pc != healthy {
+			t.Fatalf("get should skip the broken client: %v", err)
+		}
+		p.put(pc)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.clients) != 1 || p.clients[0] != healthy {
+		t.Fatalf("broken client should be dropped")
+	}
+}
+
+func TestPoolDialError(t *testing.T) {
+	p := newPool("mem@pool-nowhere", nil, &PoolOption{MaxConns: 2})
+	defer p.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := p.get(ctx)
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the dial error, got %v", err)
+	}
+	p.close()
+	if _, err := p.get(ctx); !errors.Is(err, myrpc.Errshutdown) {
+		t.Fatalf("get on a closed pool: expect %v, got %v", myrpc.Errshutdown, err)
+	}
+}
+
+func TestXClientDropsAddress(t *testing.T) {
+	addr := startServer(t, "pool-dropped")
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClientWithPool(d, RandomSelect, nil, &PoolOption{MaxConns: 1, IdleTimeout: 20 * time.Millisecond})
+	defer xc.Close()
+
+	done := make(chan error, 1)
+	var reply int
+	go func() { done <- xc.Call(context.Background(), "Slow.Sleep", 200, &reply) }()
+	var p *pool
+	for start := time.Now(); p == nil && time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		xc.mu.Lock()
+		p = xc.pools[addr]
+		xc.mu.Unlock()
+	}
+	if p == nil {
+		t.Fatal("call didn't create a pool")
+	}
+	waitFor(t, p, "the call", func() bool { return len(p.clients) == 1 && p.clients[0].inflight == 1 })
+	pc := p.clients[0]
+
+	//服务发现不再返回该地址，进行中的调用不受影响
+	_ = d.Update(nil)
+	waitFor(t, p, "the pool to close", func() bool { return p.closed })
+	if !pc.IsAvaliable() {
+		t.Fatal("checked out client closed before its call returned")
+	}
+	if err := <-done; err != nil || reply != 200 {
+		t.Fatalf("in-flight call failed: %d %v", reply, err)
+	}
+	if pc.IsAvaliable() {
+		t.Fatal("client of a dropped address should be closed once its call returns")
+	}
+}
@@ -4,62 +4,124 @@ import (
 	"context"
 	. "myrpc"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
 type XClient struct {
-	d       Discovery
-	mode    SelectMode
-	opt     *Option
-	mu      sync.Mutex
-	clients map[string]*Client
+	d     Discovery
+	mode  SelectMode
+	opt   *Option
+	popt  *PoolOption
+	mu    sync.Mutex //只保护 pools，拨号时不持有
+	pools map[string]*pool
+	done  chan struct{}
 }
 
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	r := &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*Client)}
+	return NewXClientWithPool(d, mode, opt, nil)
+}
+
+// NewXClientWithPool is NewXClient with the given pool sizes, nil means DefaultPoolOption.
+func NewXClientWithPool(d Discovery, mode SelectMode, opt *Option, popt *PoolOption) *XClient {
+	if popt == nil {
+		popt = DefaultPoolOption
+	}
+	r := &XClient{d: d, mode: mode, opt: opt, popt: popt, pools: make(map[string]*pool), done: make(chan struct{})}
+	if popt.IdleTimeout > 0 || popt.MinConns > 0 {
+		go r.maintain()
+	}
 	return r
 }
 
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		client.Close()
-		delete(xc.clients, key)
+	select {
+	case <-xc.done:
+	default:
+		close(xc.done)
+	}
+	for addr, p := range xc.pools {
+		p.close()
+		delete(xc.pools, addr)
 	}
 	return nil
 }
 
-func (xc *XClient) dial(addr string) (*Client, error) {
+func (xc *XClient) pool(addr string) (*pool, error) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	client, ok := xc.clients[addr]
-	if ok && !client.IsAvaliable() {
-		client.Close()
-		delete(xc.clients, addr)
-		client = nil
-	}
-	if client == nil {
-		var err error
-		client, err = XDial(addr, xc.opt)
-		if err != nil {
-			return nil, err
+	select {
+	case <-xc.done:
+		return nil, Errshutdown
+	default:
+	}
+	p, ok := xc.pools[addr]
+	if !ok {
+		p = newPool(addr, xc.opt, xc.popt)
+		xc.pools[addr] = p
+	}
+	return p, nil
+}
+
+// maintain periodically evicts idle clients, refills the pools up to MinConns
+// and drops the pools of the addresses the discovery no longer returns.
+func (xc *XClient) maintain() {
+	interval := xc.popt.IdleTimeout / 2
+	if interval <= 0 || interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-xc.done:
+			return
+		case <-ticker.C:
+		}
+		servers, err := xc.d.GetAll()
+		live := make(map[string]bool, len(servers))
+		for _, addr := range servers {
+			live[addr] = true
 		}
-		xc.clients[addr] = client
+		deadline := time.Now().Add(-xc.popt.IdleTimeout)
+		xc.mu.Lock()
+		for addr, p := range xc.pools {
+			if err == nil && !live[addr] {
+				p.close()
+				delete(xc.pools, addr)
+				continue
+			}
+			p.evict(deadline)
+		}
+		xc.mu.Unlock()
 	}
-	return client, nil
 }
 
 func (xc *XClient) call(rpcaddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcaddr)
+	p, err := xc.pool(rpcaddr)
+	if err != nil {
+		return err
+	}
+	pc, err := p.get(ctx)
 	if err != nil {
 		return err
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	defer p.put(pc)
+	return pc.Call(ctx, serviceMethod, args, reply)
 }
 
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode) //通过负载均衡策略获取rpc的地址
+	var rpcAddr string
+	var err error
+	if sd, ok := xc.d.(ServiceDiscovery); ok {
+		//只在提供该服务（版本）的实例中选择
+		rpcAddr, err = sd.GetFor(xc.mode, serviceOf(ctx, serviceMethod))
+	} else {
+		rpcAddr, err = xc.d.Get(xc.mode) //通过负载均衡策略获取rpc的地址
+	}
 	if err != nil {
 		return err
 	}
@@ -71,11 +133,13 @@ func (xc *XClient) BoardCast(ctx context.Context, serviceMethod string, args, re
 	if err != nil {
 		return nil
 	}
+	servers = offering(servers, serviceOf(ctx, serviceMethod))
 	//并发情况下需要使用互斥锁保证 error 和 reply 能被正确赋值。
 	var wg sync.WaitGroup
 	var e error
 	var mu sync.Mutex
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	replyDone := reply == nil //被多个协程并发访问，需要mu来保证互斥
 	for _, rpcAddr := range servers {
 		wg.Add(1)
@@ -103,3 +167,16 @@ func (xc *XClient) BoardCast(ctx context.Context, serviceMethod string, args, re
 	wg.Wait()
 	return e
 }
+
+// serviceOf returns the service part of serviceMethod, e.g. "Foo@v2" for "Foo@v2.Sum",
+// or for "Foo.Sum" called with WithVersion(ctx, "v2").
+func serviceOf(ctx context.Context, serviceMethod string) string {
+	service := serviceMethod
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		service = serviceMethod[:dot]
+	}
+	if v := VersionFrom(ctx); v != "" && !strings.Contains(service, "@") {
+		service += "@" + v
+	}
+	return service
+}
@@ -0,0 +1,64 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"myrpc"
+	"testing"
+)
+
+type VerV1 int
+
+func (v VerV1) Name(_ int, reply *string) error {
+	return errors.New("v1 shouldn't be called")
+}
+
+type VerV2 int
+
+func (v VerV2) Name(_ int, reply *string) error {
+	*reply = "v2"
+	return nil
+}
+
+// startVersioned serves rcvr as Ver@version on the mem network and returns the advertised address.
+func startVersioned(t *testing.T, name, version string, rcvr interface{}) string {
+	server := myrpc.NewServer()
+	if err := server.RegisterName("Ver@"+version, rcvr); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := server.Serve("mem@" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	addr, err := server.Advertise(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func TestXClientVersion(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{
+		startVersioned(t, "xclient-v1", "v1", new(VerV1)),
+		startVersioned(t, "xclient-v2", "v2", new(VerV2)),
+	})
+	xc := NewXClient(d, RondRobinSelect, nil)
+	defer xc.Close()
+
+	//版本来自 ctx 时，也只选提供该版本的服务端
+	ctx := myrpc.WithVersion(context.Background(), "v2")
+	for i := 0; i < 4; i++ {
+		var reply string
+		if err := xc.Call(ctx, "Ver.Name", 0, &reply); err != nil || reply != "v2" {
+			t.Fatalf("call %d: expect v2, got %q %v", i, reply, err)
+		}
+	}
+	var reply string
+	if err := xc.BoardCast(ctx, "Ver.Name", 0, &reply); err != nil || reply != "v2" {
+		t.Fatalf("broadcast: expect v2, got %q %v", reply, err)
+	}
+	if err := xc.Call(myrpc.WithVersion(context.Background(), "v3"), "Ver.Name", 0, &reply); err == nil {
+		t.Fatal("no server offers v3, the call should fail")
+	}
+}